@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"katenary/generator"
 	"katenary/generator/writers"
 	"katenary/helm"
 	"katenary/update"
@@ -21,6 +22,7 @@ The general way to use it is to call one of these commands:
     katenary convert
     katenary convert -c docker-compose.yml
     katenary convert -c docker-compose.yml -o ./charts
+    katenary convert -c compose.yaml -c compose.prod.yaml
 
 In case of, check the help of each command using:
     katenary <command> --help
@@ -58,20 +60,45 @@ func main() {
 			".\nThe appversion will be generated that way:\n" +
 			"- if it's in a git project, it takes git version or tag\n" +
 			"- if it's not defined, so the version will be get from the --app-version flag \n" +
-			"- if it's not defined, so the 0.0.1 version is used",
+			"- if it's not defined, so the 0.0.1 version is used\n" +
+			"The --compose-file flag can be repeated, files are merged in the given order.",
 		Run: func(c *cobra.Command, args []string) {
 			force := c.Flag("force").Changed
+			keepVariables := c.Flag("keep-variables").Changed
 			// TODO: is there a way to get typed values from cobra?
 			appversion := c.Flag("app-version").Value.String()
-			composeFile := c.Flag("compose-file").Value.String()
+			composeFiles, err := c.Flags().GetStringArray("compose-file")
+			if err != nil {
+				c.PrintErrln(err)
+				return
+			}
 			appName := c.Flag("app-name").Value.String()
 			chartVersion := c.Flag("chart-version").Value.String()
 			chartDir := c.Flag("output-dir").Value.String()
+			profiles, err := c.Flags().GetStringArray("profile")
+			if err != nil {
+				c.PrintErrln(err)
+				return
+			}
+			generator.Profiles = profiles
+			ingressKinds, err := c.Flags().GetStringArray("ingress-kind")
+			if err != nil {
+				c.PrintErrln(err)
+				return
+			}
+			for _, kind := range ingressKinds {
+				if kind != "ingress" && kind != "gateway" && kind != "route" {
+					c.PrintErrln("the ingress kind must be \"ingress\", \"gateway\" or \"route\", got", kind)
+					return
+				}
+			}
+			generator.IngressKinds = ingressKinds
+			generator.CopyFiles = c.Flag("copy-files").Changed
 			indentation, err := strconv.Atoi(c.Flag("indent-size").Value.String())
 			if err != nil {
 				writers.IndentSize = indentation
 			}
-			Convert(composeFile, appversion, appName, chartDir, chartVersion, force)
+			Convert(composeFiles, appversion, appName, chartDir, chartVersion, force, keepVariables)
 		},
 	}
 	convertCmd.Flags().BoolP(
@@ -80,14 +107,22 @@ func main() {
 		"app-version", "a", AppVersion, "app version")
 	convertCmd.Flags().StringP(
 		"chart-version", "v", ChartVersion, "chart version")
-	convertCmd.Flags().StringP(
-		"compose-file", "c", ComposeFile, "docker compose file")
+	convertCmd.Flags().StringArrayP(
+		"compose-file", "c", ComposeFiles, "docker compose file, can be repeated to merge override files in order")
 	convertCmd.Flags().StringP(
 		"app-name", "n", AppName, "application name")
 	convertCmd.Flags().StringP(
 		"output-dir", "o", ChartsDir, "chart directory")
 	convertCmd.Flags().IntP(
 		"indent-size", "i", 2, "set the indent size of the YAML files")
+	convertCmd.Flags().Bool(
+		"keep-variables", false, "keep compose ${VAR} variables as values instead of resolving them")
+	convertCmd.Flags().StringArray(
+		"profile", []string{}, "compose profile to enable by default in values, can be repeated (\"*\" enables all)")
+	convertCmd.Flags().StringArray(
+		"ingress-kind", []string{"ingress"}, "kind of objects for the ingress labels, \"ingress\", \"gateway\" (HTTPRoute) or \"route\" (OpenShift), can be repeated")
+	convertCmd.Flags().Bool(
+		"copy-files", false, "copy the files of the local volumes in the \"files\" directory of the chart instead of inlining them in ConfigMaps and Secrets")
 
 	// show possible labels to set in docker-compose file
 	showLabelsCmd := &cobra.Command{
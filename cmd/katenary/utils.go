@@ -12,12 +12,13 @@ import (
 )
 
 var (
-	composeFiles = []string{"compose.yml", "compose.yaml", "docker-compose.yaml", "docker-compose.yml"}
-	ComposeFile  = ""
-	AppName      = "MyApp"
-	ChartsDir    = "chart"
-	AppVersion   = "0.0.1"
-	ChartVersion = "0.1.0"
+	composeFiles         = []string{"compose.yml", "compose.yaml", "docker-compose.yaml", "docker-compose.yml"}
+	composeOverrideFiles = []string{"compose.override.yml", "compose.override.yaml", "docker-compose.override.yml", "docker-compose.override.yaml"}
+	ComposeFiles         = []string{}
+	AppName              = "MyApp"
+	ChartsDir            = "chart"
+	AppVersion           = "0.0.1"
+	ChartVersion         = "0.1.0"
 )
 
 func init() {
@@ -26,14 +27,25 @@ func init() {
 	SetAppVersion()
 }
 
+// FindComposeFile sets ComposeFiles to the first compose file found in the current directory,
+// followed by the first override file if any.
 func FindComposeFile() bool {
 	for _, file := range composeFiles {
 		if _, err := os.Stat(file); err == nil {
-			ComposeFile = file
-			return true
+			ComposeFiles = append(ComposeFiles, file)
+			break
 		}
 	}
-	return false
+	if len(ComposeFiles) == 0 {
+		return false
+	}
+	for _, file := range composeOverrideFiles {
+		if _, err := os.Stat(file); err == nil {
+			ComposeFiles = append(ComposeFiles, file)
+			break
+		}
+	}
+	return true
 }
 
 // SetAppName sets the application name from the current directory name.
@@ -93,21 +105,29 @@ func detectGitVersion() (string, error) {
 	return defaulVersion, errors.New("git log failed")
 }
 
-func Convert(composeFile, appVersion, appName, chartDir, chartVersion string, force bool) {
-	if len(composeFile) == 0 {
+func Convert(composeFiles []string, appVersion, appName, chartDir, chartVersion string, force, keepVariables bool) {
+	if len(composeFiles) == 0 {
 		fmt.Println("No compose file given")
 		return
 	}
-	_, err := os.Stat(composeFile)
-	if err != nil {
-		fmt.Println("No compose file found")
-		os.Exit(1)
+	for _, composeFile := range composeFiles {
+		if _, err := os.Stat(composeFile); err != nil {
+			fmt.Printf("No compose file found: %s\n", composeFile)
+			os.Exit(1)
+		}
 	}
 
-	// Parse the compose file now
-	p := compose.NewParser(composeFile)
+	// Parse the compose files now, in order
+	p := compose.NewParser(composeFiles)
+	p.KeepVariables = keepVariables
 	p.Parse(appName)
 
+	// the files must fit in their ConfigMap or Secret, check it before to remove the chart
+	if err := generator.CheckSizes(p.Data); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
 	dirname := filepath.Join(chartDir, appName)
 	if _, err := os.Stat(dirname); err == nil && !force {
 		response := ""
@@ -139,6 +159,6 @@ func Convert(composeFile, appVersion, appName, chartDir, chartVersion string, fo
 	}
 
 	// start generator
-	generator.Generate(p, Version, appName, appVersion, chartVersion, ComposeFile, dirname)
+	generator.Generate(p, Version, appName, appVersion, chartVersion, p.Files, dirname)
 
 }
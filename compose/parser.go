@@ -1,23 +1,40 @@
 package compose
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"katenary/helm"
+	"katenary/logger"
 	"log"
 	"os"
 	"path/filepath"
 
 	"github.com/compose-spec/compose-go/cli"
+	"github.com/compose-spec/compose-go/loader"
 	"github.com/compose-spec/compose-go/types"
+	"gopkg.in/yaml.v3"
 )
 
 const (
 	ICON_EXCLAMATION = "❕"
+
+	// EXT_CONTENT is the secret or config extension that holds the inline content.
+	EXT_CONTENT = "x-katenary-content"
 )
 
 // Parser is a docker-compose parser.
 type Parser struct {
-	Data      *types.Project
-	temporary *string
+	Data  *types.Project
+	Files []string
+
+	// KeepVariables makes the compose variables to be kept as values (see Variables) instead of
+	// being resolved at convert time. It must be set before calling Parse.
+	KeepVariables bool
+	Variables     map[string]*Variable
+
+	portTemplates map[string]map[uint32]string
+	temporary     *string
 }
 
 var (
@@ -25,33 +42,33 @@ var (
 	CURRENT_DIR, _ = os.Getwd()
 )
 
-// NewParser create a Parser and parse the file given in filename. If filename is empty, we try to parse the content[0] argument that should be a valid YAML content.
-func NewParser(filename string, content ...string) *Parser {
+// NewParser create a Parser for the given compose files. Files are loaded in order, so the next ones
+// override the previous ones (like "docker compose -f a.yaml -f b.yaml" does). If content is given,
+// each content[i] is written to filenames[i] before parsing.
+func NewParser(filenames []string, content ...string) *Parser {
 
-	p := &Parser{}
+	p := &Parser{
+		Variables:     make(map[string]*Variable),
+		portTemplates: make(map[string]map[uint32]string),
+	}
 
-	if len(content) > 0 { // mainly for the tests...
-		dir := filepath.Dir(filename)
+	for i, c := range content { // mainly for the tests...
+		if i >= len(filenames) {
+			break
+		}
+		dir := filepath.Dir(filenames[i])
 		err := os.MkdirAll(dir, 0755)
 		if err != nil {
 			log.Fatal(err)
 		}
 		p.temporary = &dir
-		ioutil.WriteFile(filename, []byte(content[0]), 0644)
-		cli.DefaultFileNames = []string{filename}
+		ioutil.WriteFile(filenames[i], []byte(c), 0644)
 	}
-	// if filename is not in cli Default files, add it
-	if len(filename) > 0 {
-		found := false
-		for _, f := range cli.DefaultFileNames {
-			if f == filename {
-				found = true
-				break
-			}
-		}
-		// add the file at first position
-		if !found {
-			cli.DefaultFileNames = append([]string{filename}, cli.DefaultFileNames...)
+
+	// keep the files that are not empty, in order
+	for _, f := range filenames {
+		if len(f) > 0 {
+			p.Files = append(p.Files, f)
 		}
 	}
 
@@ -65,27 +82,162 @@ func (p *Parser) Parse(appname string) {
 	// - set Appname
 	// - loas services
 
-	options, err := cli.NewProjectOptions(nil,
-		cli.WithDefaultConfigPath,
-		cli.WithNormalization(true),
-		cli.WithInterpolation(true),
-		cli.WithResolvedPaths(true),
-	)
+	// if no file is given, the default compose files are searched in the current directory
+	options, err := cli.NewProjectOptions(p.Files, cli.WithDefaultConfigPath)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	proj, err := cli.ProjectFromOptions(options)
+	proj, err := p.load(options)
 	if err != nil {
 		log.Fatal("Failed to create project", err)
 	}
+	if err := applyExtensions(proj); err != nil {
+		log.Fatal(err)
+	}
 
 	Appname = proj.Name
 	p.Data = proj
+	p.Files = proj.ComposeFiles
 	CURRENT_DIR = p.Data.WorkingDir
 }
 
+// load reads the compose files, adapts the raw content and loads the project. Inline "content" of
+// secrets and configs are moved to the EXT_CONTENT extension as compose-go doesn't know them. If
+// KeepVariables is set, variables are changed to templates where it's possible, and to their
+// default values elsewhere.
+func (p *Parser) load(options *cli.ProjectOptions) (*types.Project, error) {
+	files := options.ConfigPaths
+	if len(files) == 0 {
+		return nil, errors.New("no configuration file provided")
+	}
+	configs := make([]types.ConfigFile, 0)
+	for i, f := range files {
+		f, err := filepath.Abs(f)
+		if err != nil {
+			return nil, err
+		}
+		files[i] = f
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		dict := make(map[string]interface{})
+		if err := yaml.Unmarshal(content, &dict); err != nil {
+			return nil, err
+		}
+		moveInlineContents(dict)
+		if p.KeepVariables {
+			p.templateVariables(dict)
+		}
+		if content, err = yaml.Marshal(dict); err != nil {
+			return nil, err
+		}
+		configs = append(configs, types.ConfigFile{Filename: f, Content: content})
+	}
+
+	workingDir := filepath.Dir(files[0])
+	environment := options.Environment
+	if p.KeepVariables {
+		// the environment is never used to resolve variables
+		environment = map[string]string{}
+	}
+	proj, err := loader.Load(types.ConfigDetails{
+		ConfigFiles: configs,
+		WorkingDir:  workingDir,
+		Environment: environment,
+	}, func(o *loader.Options) {
+		o.SetProjectName(loader.NormalizeProjectName(filepath.Base(workingDir)), false)
+		o.ResolvePaths = true
+		if p.KeepVariables {
+			o.Interpolate.Substitute = p.substitute
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	proj.ComposeFiles = files
+
+	// keep the port templates inside the ports
+	for i, s := range proj.Services {
+		for j, port := range s.Ports {
+			if tpl, ok := p.portTemplates[s.Name][port.Target]; ok {
+				if port.Extensions == nil {
+					proj.Services[i].Ports[j].Extensions = make(map[string]interface{})
+				}
+				proj.Services[i].Ports[j].Extensions[EXT_PORT_TEMPLATE] = tpl
+			}
+		}
+	}
+
+	for name, v := range p.Variables {
+		if !v.Templated {
+			logger.ActivateColors = true
+			logger.Yellowf("Warning, the %s variable cannot be kept as value, its default value %q is used\n", name, v.Default)
+			logger.ActivateColors = false
+		}
+	}
+
+	return proj, nil
+}
+
+// applyExtensions sets the labels of the services from the "x-katenary" extensions. The extension of a service
+// takes precedence over its labels, and the labels over the top-level extension.
+func applyExtensions(proj *types.Project) error {
+	defaults := make(map[string]string)
+	if content, ok := proj.Extensions[helm.EXTENSION]; ok {
+		ext, err := helm.NewExtension(content)
+		if err != nil {
+			return err
+		}
+		if err := ext.Validate(true); err != nil {
+			return err
+		}
+		defaults = ext.Labels()
+	}
+
+	for i, s := range proj.Services {
+		if s.Labels == nil {
+			s.Labels = make(types.Labels)
+		}
+		for k, v := range defaults {
+			if _, ok := s.Labels[k]; !ok {
+				s.Labels[k] = v
+			}
+		}
+		if content, ok := s.Extensions[helm.EXTENSION]; ok {
+			ext, err := helm.NewExtension(content)
+			if err != nil {
+				return fmt.Errorf("%s service: %w", s.Name, err)
+			}
+			if err := ext.Validate(false); err != nil {
+				return fmt.Errorf("%s service: %w", s.Name, err)
+			}
+			for k, v := range ext.Labels() {
+				s.Labels[k] = v
+			}
+		}
+		proj.Services[i] = s
+	}
+	return nil
+}
+
+// moveInlineContents moves the "content" of top-level secrets and configs to the EXT_CONTENT extension.
+func moveInlineContents(dict map[string]interface{}) {
+	for _, section := range []string{"secrets", "configs"} {
+		objects, _ := dict[section].(map[string]interface{})
+		for _, object := range objects {
+			if object, ok := object.(map[string]interface{}); ok {
+				if content, ok := object["content"]; ok {
+					object[EXT_CONTENT] = content
+					delete(object, "content")
+				}
+			}
+		}
+	}
+}
+
 func GetCurrentDir() string {
 	return CURRENT_DIR
 }
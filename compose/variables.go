@@ -0,0 +1,188 @@
+package compose
+
+import (
+	"fmt"
+	"katenary/helm"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/compose-spec/compose-go/template"
+)
+
+// EXT_PORT_TEMPLATE is the port extension where the template of a target port that uses variables is kept.
+const EXT_PORT_TEMPLATE = "x-katenary-port-template"
+
+// Variable is a compose variable (${VAR}, ${VAR:-default}, ${VAR:?error}...) that is kept as a value
+// in values.yaml instead of being resolved at convert time.
+type Variable struct {
+	Name      string
+	Default   string
+	Required  bool
+	Message   string
+	Templated bool // true if the variable is used in, at least, one template
+}
+
+// variableRE matches the escaped "$$", the "$VAR" and the "${VAR}" forms, with optional
+// default (:- or -) or required (:? or ?) modifiers.
+var variableRE = regexp.MustCompile(
+	`\$(?:(\$)|([_a-zA-Z][_a-zA-Z0-9]*)|\{([_a-zA-Z][_a-zA-Z0-9]*)(?:(:?[-?])([^}]*))?\})`)
+
+// replaceVariables replaces the variables in value by their default value, or by a Helm template
+// that points on the value if asTemplate is true. Each found variable is registered in p.Variables.
+func (p *Parser) replaceVariables(value string, asTemplate bool) string {
+	return variableRE.ReplaceAllStringFunc(value, func(match string) string {
+		groups := variableRE.FindStringSubmatch(match)
+		if groups[1] != "" { // escaped
+			if asTemplate {
+				// kept as is, compose-go unescapes it when the project is loaded
+				return "$$"
+			}
+			return "$"
+		}
+
+		name := groups[2]
+		if name == "" {
+			name = groups[3]
+		}
+		v, ok := p.Variables[name]
+		if !ok {
+			v = &Variable{Name: name}
+			p.Variables[name] = v
+		}
+		switch groups[4] {
+		case ":-", "-":
+			if v.Default == "" {
+				v.Default = groups[5]
+			}
+		case ":?", "?":
+			v.Required = true
+			if v.Message == "" {
+				v.Message = groups[5]
+			}
+		}
+
+		if !asTemplate {
+			return v.Default
+		}
+		v.Templated = true
+		if v.Required {
+			message := v.Message
+			if message == "" {
+				message = name + " is required"
+			}
+			return fmt.Sprintf(`{{ required %s .Values.%s }}`, strconv.Quote(message), name)
+		}
+		return "{{ .Values." + name + " }}"
+	})
+}
+
+// substitute is the compose-go substitution function used when variables are kept. The fields that
+// cannot be templated get the default value of the variables, the environment is never used.
+func (p *Parser) substitute(value string, _ template.Mapping) (string, error) {
+	return p.replaceVariables(value, false), nil
+}
+
+// templateVariables changes, in the raw compose content, the variables used by the image, the
+// environment and the mapenv label or extension to templates. Ports that use variables are registered to be
+// templated after loading.
+func (p *Parser) templateVariables(dict map[string]interface{}) {
+	services, _ := dict["services"].(map[string]interface{})
+	for name, service := range services {
+		service, ok := service.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if image, ok := service["image"].(string); ok {
+			service["image"] = p.replaceVariables(image, true)
+		}
+
+		switch env := service["environment"].(type) {
+		case map[string]interface{}:
+			for k, v := range env {
+				if v, ok := v.(string); ok {
+					env[k] = p.replaceVariables(v, true)
+				}
+			}
+		case []interface{}:
+			for i, v := range env {
+				if v, ok := v.(string); ok {
+					env[i] = p.replaceVariables(v, true)
+				}
+			}
+		}
+
+		switch labels := service["labels"].(type) {
+		case map[string]interface{}:
+			if v, ok := labels[helm.LABEL_MAP_ENV].(string); ok {
+				labels[helm.LABEL_MAP_ENV] = p.replaceVariables(v, true)
+			}
+		case []interface{}:
+			for i, v := range labels {
+				if v, ok := v.(string); ok && strings.HasPrefix(v, helm.LABEL_MAP_ENV+"=") {
+					labels[i] = p.replaceVariables(v, true)
+				}
+			}
+		}
+
+		if ext, ok := service[helm.EXTENSION].(map[string]interface{}); ok {
+			if mapenv, ok := ext["mapenv"].(map[string]interface{}); ok {
+				for k, v := range mapenv {
+					if v, ok := v.(string); ok {
+						mapenv[k] = p.replaceVariables(v, true)
+					}
+				}
+			}
+		}
+
+		if ports, ok := service["ports"].([]interface{}); ok {
+			for _, port := range ports {
+				p.registerPortTemplate(name, port)
+			}
+		}
+	}
+}
+
+// registerPortTemplate keeps the template of the target port if it uses variables. Port ranges
+// are resolved as they cannot be templated.
+func (p *Parser) registerPortTemplate(service string, port interface{}) {
+	target := ""
+	switch port := port.(type) {
+	case string:
+		// [[ip:]published:]target[/protocol], the ":" inside variables are not separators
+		target = port
+		depth := 0
+	search:
+		for i := len(port) - 1; i >= 0; i-- {
+			switch port[i] {
+			case '}':
+				depth++
+			case '{':
+				depth--
+			case ':':
+				if depth == 0 {
+					target = port[i+1:]
+					break search
+				}
+			}
+		}
+		if i := strings.LastIndex(target, "/"); i > -1 && !strings.Contains(target[i:], "}") {
+			target = target[:i]
+		}
+	case map[string]interface{}:
+		target, _ = port["target"].(string)
+	}
+
+	if !variableRE.MatchString(target) {
+		return
+	}
+	resolved, err := strconv.Atoi(p.replaceVariables(target, false))
+	if err != nil {
+		return
+	}
+	if _, ok := p.portTemplates[service]; !ok {
+		p.portTemplates[service] = make(map[uint32]string)
+	}
+	p.portTemplates[service][uint32(resolved)] = p.replaceVariables(target, true)
+}
@@ -1,19 +1,25 @@
 package generator
 
 import (
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"katenary/compose"
 	"katenary/helm"
 	"katenary/logger"
 	"log"
+	"math"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/compose-spec/compose-go/types"
 	"gopkg.in/yaml.v3"
@@ -30,6 +36,9 @@ const (
 	ICON_INGRESS = "🌐"
 )
 
+// maxConfigMapSize is the maximum size of the data of a ConfigMap, in bytes.
+const maxConfigMapSize = 1024 * 1024
+
 // Values is kept in memory to create a values.yaml file.
 var (
 	Values       = make(map[string]map[string]interface{})
@@ -38,6 +47,22 @@ var (
 	servicesMap  = make(map[string]int)
 	locker       = &sync.Mutex{}
 
+	// compose secrets and configs declared at the top level, set by Generate
+	composeSecrets = make(types.Secrets)
+	composeConfigs = make(types.Configs)
+
+	// Profiles are the compose profiles that are enabled by default in values.yaml. Services without
+	// profile are always enabled, "*" enables all services.
+	Profiles = []string{}
+
+	// IngressKinds are the kinds of objects that expose the services with an ingress label, "ingress" for
+	// Ingresses, "gateway" for Gateway API HTTPRoutes and "route" for OpenShift Routes.
+	IngressKinds = []string{"ingress"}
+
+	// CopyFiles copies the files of the local volumes in the "files" directory of the chart, the ConfigMaps and
+	// the Secrets load them with .Files instead of having their content.
+	CopyFiles = false
+
 	dependScript = `
 OK=0
 echo "Checking __service__ port"
@@ -50,6 +75,31 @@ echo "Done"
 `
 
 	madeDeployments = make(map[string]helm.Deployment, 0)
+
+	// sharedVolumes are the named volumes mounted by several deployments, by volume name, set by Generate
+	sharedVolumes = make(map[string]*sharedVolume)
+
+	// namedVolumes are the names of the compose named volumes, without the minus signs, set by Generate
+	namedVolumes = make(map[string]bool)
+
+	// sharedIngresses are the paths of the ingresses shared by several services, by group name
+	sharedIngresses = make(map[string][]*sharedIngressPath)
+
+	// groupNameRE matches the names of the shared ingress groups, they are used as values keys
+	groupNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	// statefulImages are the well-known database images that are deployed as StatefulSet when
+	// they use named volumes.
+	statefulImages = map[string]bool{
+		"postgres": true, "postgresql": true, "postgis": true, "timescaledb": true,
+		"mysql": true, "mariadb": true, "percona-server": true,
+		"mongo": true, "mongodb": true,
+		"redis": true, "valkey": true, "keydb": true,
+		"elasticsearch": true, "opensearch": true,
+		"cassandra": true, "couchdb": true, "influxdb": true, "neo4j": true,
+		"clickhouse-server": true, "cockroach": true,
+		"rabbitmq": true, "zookeeper": true, "kafka": true, "etcd": true,
+	}
 )
 
 // Create a Deployment for a given compose.Service. It returns a list chan
@@ -64,8 +114,44 @@ func CreateReplicaObject(name string, s types.ServiceConfig, linked map[string]t
 // This function will try to yied deployment and services based on a service from the compose file structure.
 func buildDeployment(name string, s *types.ServiceConfig, linked map[string]types.ServiceConfig, fileGeneratorChan HelmFileGenerator) {
 
-	logger.Magenta(ICON_PACKAGE+" Generating deployment for ", name)
-	deployment := helm.NewDeployment(name)
+	var deployment *helm.Deployment
+	kind := workloadKind(name, s)
+	isCronJob := kind == "cronjob"
+	switch kind {
+	case "cronjob":
+		logger.Magenta(ICON_PACKAGE+" Generating cronjob for ", name)
+		// only the pod template of the deployment is used by the cronjob
+		deployment = helm.NewDeployment(name)
+		AddValues(name, map[string]EnvVal{"cronjob": cronJobValues(name, s.Labels[helm.LABEL_CRONJOB])})
+	case "statefulset":
+		if _, ok := s.Labels[helm.LABEL_WORKLOAD]; !ok {
+			logger.Magentaf(ICON_STORE+" %s uses a database image with the %s volume, it will be a statefulset\n",
+				name, statefulVolume(s))
+		}
+		logger.Magenta(ICON_PACKAGE+" Generating statefulset for ", name)
+		deployment = helm.NewStatefulSet(name, name+"-headless")
+	case "daemonset":
+		logger.Magenta(ICON_PACKAGE+" Generating daemonset for ", name)
+		deployment = helm.NewDaemonSet(name)
+		AddValues(name, map[string]EnvVal{
+			"tolerations": []interface{}{},
+			"updateStrategy": map[string]EnvVal{
+				"type": "RollingUpdate",
+				"rollingUpdate": map[string]EnvVal{
+					"maxUnavailable": 1,
+				},
+			},
+		})
+	default:
+		logger.Magenta(ICON_PACKAGE+" Generating deployment for ", name)
+		deployment = helm.NewDeployment(name)
+	}
+	if kind == "deployment" || kind == "statefulset" {
+		prepareReplicas(name, s, deployment, fileGeneratorChan)
+	}
+
+	// every object of the service can be disabled from values, following the compose profiles
+	AddValues(name, map[string]EnvVal{"enabled": isEnabledByProfiles(s)})
 
 	newContainerForDeployment(name, name, deployment, s, fileGeneratorChan)
 
@@ -101,8 +187,13 @@ func buildDeployment(name string, s *types.ServiceConfig, linked map[string]type
 	}
 	deployment.Spec.Template.Spec.Volumes = volumes
 
-	// Then, create Services and possible Ingresses for ingress labels, "ports" and "expose" section
-	if len(s.Ports) > 0 || len(s.Expose) > 0 {
+	// Then, create Services and possible Ingresses for ingress labels, "ports" and "expose" section. The pods of
+	// a CronJob only live during the jobs, they are not exposed.
+	if isCronJob && (len(s.Ports) > 0 || len(s.Expose) > 0) {
+		logger.ActivateColors = true
+		logger.Yellowf("Warning, %s is a cronjob, its ports are not exposed by a service or an ingress\n", name)
+		logger.ActivateColors = false
+	} else if len(s.Ports) > 0 || len(s.Expose) > 0 {
 		for _, s := range generateServicesAndIngresses(name, s) {
 			if s != nil {
 				fileGeneratorChan <- s
@@ -110,18 +201,270 @@ func buildDeployment(name string, s *types.ServiceConfig, linked map[string]type
 		}
 	}
 
+	// a StatefulSet needs a headless service to give a network identity to its pods
+	if deployment.IsStatefulSet() {
+		fileGeneratorChan <- buildHeadlessService(name, s)
+	}
+
 	// add the volumes in Values
-	if len(VolumeValues[name]) > 0 {
-		AddValues(name, map[string]EnvVal{"persistence": VolumeValues[name]})
+	locker.Lock()
+	persistence := VolumeValues[name]
+	locker.Unlock()
+	if len(persistence) > 0 {
+		AddValues(name, map[string]EnvVal{"persistence": persistence})
 	}
 
 	// the deployment is ready, give it
-	fileGeneratorChan <- deployment
+	if isCronJob {
+		fileGeneratorChan <- helm.NewCronJob(name, deployment)
+	} else {
+		fileGeneratorChan <- deployment
+	}
 
 	// and then, we can say that it's the end
 	fileGeneratorChan <- nil
 }
 
+// isEnabledByProfiles returns true if the service has no profile or if one of its profiles is in Profiles.
+func isEnabledByProfiles(s *types.ServiceConfig) bool {
+	for _, p := range Profiles {
+		if p == "*" {
+			return true
+		}
+	}
+	return s.HasProfile(Profiles)
+}
+
+// workloadKind returns the kind of workload of the service: "cronjob", "deployment", "statefulset" or "daemonset".
+// Services with a schedule are cronjobs, else the kind is set by the LABEL_WORKLOAD label. Else, services in
+// "global" deploy mode are daemonsets, and the well-known databases that use named volumes are statefulsets.
+func workloadKind(name string, s *types.ServiceConfig) string {
+	if _, ok := s.Labels[helm.LABEL_CRONJOB]; ok {
+		return "cronjob"
+	}
+	if workload, ok := s.Labels[helm.LABEL_WORKLOAD]; ok {
+		workload = strings.ToLower(strings.TrimSpace(workload))
+		switch workload {
+		case "deployment", "statefulset", "daemonset":
+			return workload
+		default:
+			log.Fatalf("The workload \"%s\" of \"%s\" service is not valid, "+
+				"use \"deployment\", \"statefulset\" or \"daemonset\"\n", workload, name)
+		}
+	}
+
+	if s.Deploy != nil && s.Deploy.Mode == "global" {
+		return "daemonset"
+	}
+
+	if statefulVolume(s) != "" {
+		return "statefulset"
+	}
+	return "deployment"
+}
+
+// statefulVolume returns the named volume that makes a well-known database image to be a StatefulSet, or an empty
+// string.
+func statefulVolume(s *types.ServiceConfig) string {
+	image, _ := splitImage(s.Image)
+	if !statefulImages[image[strings.LastIndex(image, "/")+1:]] {
+		return ""
+	}
+	for _, vol := range s.Volumes {
+		if vol.Type == types.VolumeTypeVolume && vol.Source != "" && !isEmptyDir(vol.Source) {
+			return vol.Source
+		}
+	}
+	return ""
+}
+
+// sharedVolume is a named volume mounted by several deployments. The claim is created by the owner, the first
+// deployment by name that is enabled by profiles, and the others use it.
+type sharedVolume struct {
+	owner       string
+	deployments []string
+	mode        string // "rwx" or "affinity"
+}
+
+// findSharedVolumes finds the named volumes that are mounted by several deployments, the containers of the same pod
+// are in the same deployment. StatefulSets are not sharing their volumes as each pod gets its own claim. The
+// sharing mode is given by the volume-sharing label of the deployments.
+func findSharedVolumes(services types.Services) map[string]*sharedVolume {
+	deploymentsByVolume := make(map[string]map[string]bool)
+	modes := make(map[string]string)
+	stateful := make(map[string]bool)
+	enabled := make(map[string]bool)
+	for _, s := range services {
+		if workloadKind(s.Name, &s) == "statefulset" {
+			stateful[s.Name] = true
+		}
+		enabled[s.Name] = isEnabledByProfiles(&s)
+	}
+	// the mode is the one of the first service by name
+	sorted := append(types.Services{}, services...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	for _, s := range sorted {
+		deployName := s.Name
+		if samepod, ok := s.Labels[helm.LABEL_SAMEPOD]; ok {
+			deployName = samepod
+		}
+		for _, vol := range s.Volumes {
+			if vol.Type != types.VolumeTypeVolume || vol.Source == "" || isEmptyDir(vol.Source) {
+				continue
+			}
+			volname := strings.ReplaceAll(vol.Source, "-", "")
+			if stateful[deployName] {
+				if _, ok := deploymentsByVolume[volname]; ok {
+					logger.ActivateColors = true
+					logger.Yellowf("Warning, the %s statefulset gets its own %s volume, it's not shared\n", deployName, vol.Source)
+					logger.ActivateColors = false
+				}
+				continue
+			}
+			if _, ok := deploymentsByVolume[volname]; !ok {
+				deploymentsByVolume[volname] = make(map[string]bool)
+			}
+			deploymentsByVolume[volname][deployName] = true
+			if mode, ok := s.Labels[helm.LABEL_VOL_SHARING]; ok {
+				mode = strings.ToLower(strings.TrimSpace(mode))
+				if mode != "rwx" && mode != "affinity" {
+					log.Fatalf("The volume sharing \"%s\" of \"%s\" service is not valid, use \"rwx\" or \"affinity\"\n", mode, s.Name)
+				}
+				if modes[volname] == "" {
+					modes[volname] = mode
+				} else if modes[volname] != mode {
+					logger.ActivateColors = true
+					logger.Yellowf("Warning, %s sets the \"%s\" volume sharing of the %s volume, but \"%s\" is already set "+
+						"by another service -- ignored\n", s.Name, mode, vol.Source, modes[volname])
+					logger.ActivateColors = false
+				}
+			}
+		}
+	}
+
+	shared := make(map[string]*sharedVolume)
+	for volname, deployments := range deploymentsByVolume {
+		if len(deployments) < 2 {
+			continue
+		}
+		names := make([]string, 0, len(deployments))
+		for name := range deployments {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		mode := modes[volname]
+		if mode == "" {
+			mode = "rwx"
+		}
+		// the owner is enabled by default if possible, as the others use its claim and run on its node
+		owner := names[0]
+		for _, name := range names {
+			if enabled[name] {
+				owner = name
+				break
+			}
+		}
+		shared[volname] = &sharedVolume{owner: owner, deployments: names, mode: mode}
+		if mode == "rwx" {
+			logger.Magentaf(ICON_STORE+" The %s volume is shared by %s, it will be one ReadWriteMany claim\n",
+				volname, strings.Join(names, ", "))
+		} else {
+			logger.Magentaf(ICON_STORE+" The %s volume is shared by %s, the pods will run on the node of %s\n",
+				volname, strings.Join(names, ", "), owner)
+		}
+	}
+	return shared
+}
+
+// addPodAffinity makes the pods of the deployment to run on the node of the pods of the owner deployment.
+func addPodAffinity(deployment *helm.Deployment, owner string) {
+	spec := &deployment.Spec.Template.Spec
+	if spec.Affinity == nil {
+		spec.Affinity = map[string]interface{}{
+			"podAffinity": map[string]interface{}{
+				"requiredDuringSchedulingIgnoredDuringExecution": []map[string]interface{}{},
+			},
+		}
+	}
+	podAffinity := spec.Affinity["podAffinity"].(map[string]interface{})
+	terms := podAffinity["requiredDuringSchedulingIgnoredDuringExecution"].([]map[string]interface{})
+	for _, term := range terms {
+		if term["labelSelector"].(map[string]interface{})["matchLabels"].(map[string]string)[helm.K+"/component"] == owner {
+			return
+		}
+	}
+	spec.AffinityOwners = append(spec.AffinityOwners, owner)
+	podAffinity["requiredDuringSchedulingIgnoredDuringExecution"] = append(terms, map[string]interface{}{
+		"labelSelector": map[string]interface{}{
+			"matchLabels": buildSelector(owner, nil),
+		},
+		"topologyKey": "kubernetes.io/hostname",
+	})
+}
+
+// prepareReplicas sets the replicas of the deployment from the "deploy.replicas" (or "scale") of the service, in
+// values. An autoscaler, disabled by default, can manage the replicas instead.
+func prepareReplicas(name string, s *types.ServiceConfig, deployment *helm.Deployment, fileGeneratorChan HelmFileGenerator) {
+	replicas := 1
+	if s.Deploy != nil && s.Deploy.Replicas != nil {
+		replicas = int(*s.Deploy.Replicas)
+	} else if s.Scale > 1 {
+		replicas = s.Scale
+	}
+	maxReplicas := 10
+	if replicas > maxReplicas {
+		maxReplicas = replicas
+	}
+
+	deployment.Spec.Replicas = "{{ .Values." + name + ".replicaCount }}"
+	AddValues(name, map[string]EnvVal{
+		"replicaCount": replicas,
+		"autoscaling": map[string]EnvVal{
+			"enabled":                           false,
+			"minReplicas":                       replicas,
+			"maxReplicas":                       maxReplicas,
+			"targetCPUUtilizationPercentage":    80,
+			"targetMemoryUtilizationPercentage": 0,
+		},
+	})
+	fileGeneratorChan <- helm.NewHPA(name, deployment)
+}
+
+// cronJobValues returns the values of the cronjob, the schedule is a cron expression or a macro like "@daily".
+func cronJobValues(name, schedule string) map[string]EnvVal {
+	schedule = strings.TrimSpace(schedule)
+	if !strings.HasPrefix(schedule, "@") && len(strings.Fields(schedule)) != 5 {
+		log.Fatalf("The schedule \"%s\" of \"%s\" service is not a valid cron expression\n", schedule, name)
+	}
+	return map[string]EnvVal{
+		"schedule":                   schedule,
+		"concurrencyPolicy":          "Forbid",
+		"suspend":                    false,
+		"successfulJobsHistoryLimit": 3,
+		"failedJobsHistoryLimit":     1,
+	}
+}
+
+// isEmptyDir returns true if the volume is declared as emptyDir.
+func isEmptyDir(volname string) bool {
+	volname = strings.ReplaceAll(volname, "-", "")
+	for _, v := range EmptyDirs {
+		if strings.ReplaceAll(v, "-", "") == volname {
+			return true
+		}
+	}
+	return false
+}
+
+// buildHeadlessService creates the headless service of a StatefulSet, with the same ports than the service.
+func buildHeadlessService(name string, s *types.ServiceConfig) *helm.Service {
+	logger.Magenta(ICON_SERVICE+" Generating headless service for ", name)
+	ks := helm.NewHeadlessService(name)
+	addServicePorts(ks, getPorts(name, s))
+	ks.Spec.Selector = buildSelector(name, s)
+	return ks
+}
+
 // prepareContainer assigns image, command, env, and labels to a container.
 func prepareContainer(container *helm.Container, service *types.ServiceConfig, servicename string) {
 	// if there is no image name, this should fail!
@@ -130,104 +473,354 @@ func prepareContainer(container *helm.Container, service *types.ServiceConfig, s
 	}
 
 	// Get the image tag
-	imageParts := strings.Split(service.Image, ":")
-	tag := ""
-	if len(imageParts) == 2 {
-		container.Image = imageParts[0]
-		tag = imageParts[1]
-	}
+	image, tag := splitImage(service.Image)
 
+	// image and tag can use compose variables kept as values, so they are rendered with "tpl"
 	vtag := ".Values." + servicename + ".repository.tag"
-	container.Image = `{{ .Values.` + servicename + `.repository.image }}` +
-		`{{ if ne ` + vtag + ` "" }}:{{ ` + vtag + ` }}{{ end }}`
+	container.Image = `{{ tpl .Values.` + servicename + `.repository.image . }}` +
+		`{{ if ne ` + vtag + ` "" }}:{{ tpl ` + vtag + ` . }}{{ end }}`
 	container.Command = service.Command
 	AddValues(servicename, map[string]EnvVal{
 		"repository": map[string]EnvVal{
-			"image": imageParts[0],
+			"image": image,
 			"tag":   tag,
 		},
 	})
 	prepareProbes(servicename, service, container)
 	generateContainerPorts(service, servicename, container)
+	prepareResources(servicename, service, container)
+}
+
+// prepareResources sets the resources of the container in values, from the "deploy.resources" of the service,
+// or from "cpus", "mem_limit" and "mem_reservation".
+func prepareResources(name string, s *types.ServiceConfig, container *helm.Container) {
+	limits := make(map[string]EnvVal)
+	requests := make(map[string]EnvVal)
+
+	addResource := func(to map[string]EnvVal, cpus string, memory int64) {
+		if cpus != "" {
+			cpu, err := cpuQuantity(cpus)
+			if err != nil {
+				logger.ActivateColors = true
+				logger.Redf("The CPU resource of %s is not valid: %s -- skipping\n", name, err)
+				logger.ActivateColors = false
+			} else {
+				to["cpu"] = cpu
+			}
+		}
+		if memory > 0 {
+			to["memory"] = memoryQuantity(memory)
+		}
+	}
+
+	if s.CPUS > 0 {
+		addResource(limits, strconv.FormatFloat(float64(s.CPUS), 'f', -1, 32), 0)
+	}
+	addResource(limits, "", int64(s.MemLimit))
+	addResource(requests, "", int64(s.MemReservation))
+	if s.Deploy != nil {
+		// deploy.resources takes precedence
+		if r := s.Deploy.Resources.Limits; r != nil {
+			addResource(limits, r.NanoCPUs, int64(r.MemoryBytes))
+		}
+		if r := s.Deploy.Resources.Reservations; r != nil {
+			addResource(requests, r.NanoCPUs, int64(r.MemoryBytes))
+		}
+	}
+
+	resources := make(map[string]EnvVal)
+	if len(limits) > 0 {
+		resources["limits"] = limits
+	}
+	if len(requests) > 0 {
+		resources["requests"] = requests
+	}
+	AddValues(name, map[string]EnvVal{"resources": resources})
+	container.Resources = "{{ toYaml .Values." + name + ".resources }}"
 }
 
-// Create a service (k8s).
+// splitImage returns the image name and the tag. The tag is after the first ":" following the
+// last "/", so registry ports (e.g. localhost:5000/image) are not taken as tag.
+func splitImage(image string) (string, string) {
+	slash := strings.LastIndex(image, "/")
+	if colon := strings.Index(image[slash+1:], ":"); colon > -1 {
+		colon += slash + 1
+		return image[:colon], image[colon+1:]
+	}
+	return image, ""
+}
+
+// portValue returns the target port of the compose port, or its template if it uses variables.
+func portValue(port types.ServicePortConfig) interface{} {
+	if tpl, ok := port.Extensions[compose.EXT_PORT_TEMPLATE]; ok {
+		return tpl
+	}
+	return int(port.Target)
+}
+
+// Create a service (k8s). The "ports" and "expose" ports are set in the same service, only the published
+// ports can get a node port. The type and the other options of the service are set in values.
 func generateServicesAndIngresses(name string, s *types.ServiceConfig) []HelmFile {
 
 	ret := make([]HelmFile, 0) // can handle helm.Service or helm.Ingress
 	logger.Magenta(ICON_SERVICE+" Generating service for ", name)
 	ks := helm.NewService(name)
-
-	for _, p := range s.Ports {
-		target := int(p.Target)
-		ks.Spec.Ports = append(ks.Spec.Ports, helm.NewServicePort(target, target))
+	ks.Spec.Type = "{{ .Values." + name + ".service.type }}"
+
+	ports := getPorts(name, s)
+	addServicePorts(ks, ports)
+	nodePorts := make(map[string]EnvVal)
+	for i, p := range ports {
+		if p.published {
+			nodePorts[p.name] = nil
+			ks.Spec.Ports[i].NodePort = fmt.Sprintf(`{{ index .Values.%s.service.nodePorts "%s" }}`, name, p.name)
+		}
 	}
 	ks.Spec.Selector = buildSelector(name, s)
+	AddValues(name, map[string]EnvVal{
+		"service": map[string]EnvVal{
+			"type":                  "ClusterIP",
+			"headless":              false,
+			"annotations":           map[string]string{},
+			"loadBalancerIP":        "",
+			"externalTrafficPolicy": "",
+			"nodePorts":             nodePorts,
+		},
+	})
 
 	ret = append(ret, ks)
-	if v, ok := s.Labels[helm.LABEL_INGRESS]; ok {
-		port, err := strconv.Atoi(v)
+	if v, ok := s.Labels[helm.LABEL_SHARED_INGR]; ok {
+		addSharedIngressPath(name, v, s, ports)
+	} else if v, ok := s.Labels[helm.LABEL_INGRESS]; ok {
+		rules, simple, err := helm.ParseIngressLabel(v)
 		if err != nil {
-			log.Fatalf("The given port \"%v\" as ingress port in \"%s\" service is not an integer\n", v, name)
+			log.Fatalf("The ingress label of \"%s\" service is not valid: %v\n", name, err)
 		}
-		logger.Cyanf(ICON_INGRESS+" Create an ingress for port %d on %s service\n", port, name)
-		ing := createIngress(name, port, s)
+		ing := createIngress(name, rules, simple, ports)
 		ret = append(ret, ing)
 	}
 
-	if len(s.Expose) > 0 {
-		logger.Magenta(ICON_SERVICE+" Generating service for ", name+"-external")
-		ks := helm.NewService(name + "-external")
-		ks.Spec.Type = "NodePort"
-		for _, expose := range s.Expose {
-
-			p, _ := strconv.Atoi(expose)
-			ks.Spec.Ports = append(ks.Spec.Ports, helm.NewServicePort(p, p))
-		}
-		ks.Spec.Selector = buildSelector(name, s)
-		ret = append(ret, ks)
-	}
-
 	return ret
 }
 
-// Create an ingress.
-func createIngress(name string, port int, s *types.ServiceConfig) *helm.Ingress {
+// Create an ingress. The ports of the rules are container ports, the backends use the port of the service. With
+// the simple form of the label (a port), the host is set in .Values.<name>.ingress.host, else the host and the
+// paths of each rule are set in .Values.<name>.ingress.rules.
+func createIngress(name string, rules []*helm.IngressRuleConfig, simple bool, ports []*servicePort) *helm.Ingress {
 	ingress := helm.NewIngress(name)
 
-	annotations := map[string]string{}
-	ingressVal := map[string]interface{}{
-		"class":       "nginx",
-		"host":        name + "." + helm.Appname + ".tld",
-		"enabled":     false,
-		"annotations": annotations,
+	ingressVal := ingressValues()
+	defaultHost := name + "." + helm.Appname + ".tld"
+	if simple {
+		ingressVal["host"] = defaultHost
+	}
+
+	valueRules := make([]map[string]interface{}, 0, len(rules))
+	for i, rule := range rules {
+		host := rule.Host
+		if host == "" {
+			host = defaultHost
+		}
+		valuePaths := make([]map[string]interface{}, 0, len(rule.Paths))
+		ingressRule := helm.IngressRule{
+			Host: fmt.Sprintf("{{ (index .Values.%s.ingress.rules %d).host }}", name, i),
+		}
+		if simple {
+			ingressRule.Host = fmt.Sprintf("{{ .Values.%s.ingress.host }}", name)
+		}
+		for j, p := range rule.Paths {
+			port, portName := ingressPort(p.Port, ports)
+			logger.Cyanf(ICON_INGRESS+" Create an ingress for port %d on %s service, host %s, path %s\n", port, name, host, p.Path)
+			path := p.Path
+			if !simple {
+				path = fmt.Sprintf("{{ (index (index .Values.%s.ingress.rules %d).paths %d).path }}", name, i, j)
+			}
+			ingressRule.Http.Paths = append(ingressRule.Http.Paths, helm.IngressPath{
+				Path:     path,
+				PathType: p.PathType,
+				Backend: &helm.IngressBackend{
+					Service: helm.IngressService{
+						Name: helm.ReleaseNameTpl + "-" + name,
+						Port: map[string]interface{}{
+							"number": port,
+						},
+					},
+				},
+				PortName: portName,
+			})
+			valuePaths = append(valuePaths, map[string]interface{}{"path": p.Path})
+		}
+		ingress.Spec.Rules = append(ingress.Spec.Rules, ingressRule)
+		valueRules = append(valueRules, map[string]interface{}{"host": host, "paths": valuePaths})
+	}
+	if !simple {
+		ingressVal["rules"] = valueRules
 	}
 
 	// add Annotations in values
 	AddValues(name, map[string]EnvVal{"ingress": ingressVal})
 
-	ingress.Spec.Rules = []helm.IngressRule{
-		{
-			Host: fmt.Sprintf("{{ .Values.%s.ingress.host }}", name),
-			Http: helm.IngressHttp{
-				Paths: []helm.IngressPath{{
-					Path:     "/",
-					PathType: "Prefix",
-					Backend: &helm.IngressBackend{
-						Service: helm.IngressService{
-							Name: helm.ReleaseNameTpl + "-" + name,
-							Port: map[string]interface{}{
-								"number": port,
-							},
+	ingress.SetIngressClass(name)
+	ingress.SetTLS(name)
+
+	return ingress
+}
+
+// sharedIngressPath is the path of a service in a shared ingress.
+type sharedIngressPath struct {
+	service  string
+	path     string
+	port     int
+	portName string
+}
+
+// addSharedIngressPath registers the service in the shared ingress given by the label, as "group[/path]". The
+// port is the one of the ingress label, or the first TCP port of the service.
+func addSharedIngressPath(name, label string, s *types.ServiceConfig, ports []*servicePort) {
+	group, path := label, "/"
+	if i := strings.Index(label, "/"); i > -1 {
+		group, path = label[:i], label[i:]
+	}
+	group = strings.TrimSpace(group)
+	if !groupNameRE.MatchString(group) {
+		log.Fatalf("The shared ingress group \"%s\" of \"%s\" service must be made of letters, digits and \"_\"\n", group, name)
+	}
+
+	port, portName := 0, ""
+	if v, ok := s.Labels[helm.LABEL_INGRESS]; ok {
+		rules, simple, err := helm.ParseIngressLabel(v)
+		if err != nil || !simple {
+			log.Fatalf("The ingress label of \"%s\" service must be a port when the service is in a shared ingress\n", name)
+		}
+		port, portName = ingressPort(rules[0].Paths[0].Port, ports)
+	} else {
+		for _, p := range ports {
+			if p.protocol == "TCP" {
+				port, portName = p.port, p.name
+				break
+			}
+		}
+	}
+	if port == 0 {
+		log.Fatalf("The \"%s\" service has got no TCP port for the \"%s\" shared ingress\n", name, group)
+	}
+
+	logger.Cyanf(ICON_INGRESS+" Add the path %s to the %s shared ingress for port %d on %s service\n", path, group, port, name)
+	locker.Lock()
+	defer locker.Unlock()
+	sharedIngresses[group] = append(sharedIngresses[group], &sharedIngressPath{
+		service:  name,
+		path:     path,
+		port:     port,
+		portName: portName,
+	})
+}
+
+// buildSharedIngresses creates an ingress for each group of services that share a host, each service is
+// reached on its path. The host and the paths are set in .Values.<group>.ingress, a group named after a service is
+// prefixed by "shared" to not override the values of the service. It must be called when all the generators are
+// finished, as the shared paths are read without lock.
+func buildSharedIngresses() map[string]*helm.Ingress {
+	ingresses := make(map[string]*helm.Ingress)
+	for group, paths := range sharedIngresses {
+		sort.Slice(paths, func(i, j int) bool { return paths[i].service < paths[j].service })
+
+		locker.Lock()
+		_, isService := Values[group]
+		locker.Unlock()
+		if isService {
+			logger.ActivateColors = true
+			logger.Yellowf("Warning, the %s shared ingress has got the name of a service, it's renamed to shared%s\n",
+				group, group)
+			logger.ActivateColors = false
+			group = "shared" + group
+		}
+
+		ingress := helm.NewIngress(group)
+		rule := helm.IngressRule{Host: fmt.Sprintf("{{ .Values.%s.ingress.host }}", group)}
+		valuePaths := make(map[string]interface{})
+		for _, p := range paths {
+			valuePaths[p.service] = p.path
+			rule.Http.Paths = append(rule.Http.Paths, helm.IngressPath{
+				Path:     fmt.Sprintf(`{{ index .Values.%s.ingress.paths "%s" }}`, group, p.service),
+				PathType: "Prefix",
+				Backend: &helm.IngressBackend{
+					Service: helm.IngressService{
+						Name: helm.ReleaseNameTpl + "-" + p.service,
+						Port: map[string]interface{}{
+							"number": p.port,
 						},
 					},
-				}},
-			},
+				},
+				Service:  p.service,
+				PortName: p.portName,
+			})
+		}
+		ingress.Spec.Rules = []helm.IngressRule{rule}
+		ingress.SetIngressClass(group)
+		ingress.SetTLS(group)
+
+		ingressVal := ingressValues()
+		ingressVal["host"] = group + "." + helm.Appname + ".tld"
+		ingressVal["paths"] = valuePaths
+		AddValues(group, map[string]EnvVal{"enabled": true, "ingress": ingressVal})
+		ingresses[group] = ingress
+	}
+	return ingresses
+}
+
+// addGatewayValues sets the Gateway that the routes of the service are attached to in values.
+func addGatewayValues(name string) {
+	locker.Lock()
+	defer locker.Unlock()
+	if ingress, ok := Values[name]["ingress"].(map[string]interface{}); ok {
+		ingress["gateway"] = map[string]interface{}{
+			"name":        "gateway",
+			"namespace":   "",
+			"sectionName": "",
+		}
+	}
+}
+
+// addRouteValues sets the TLS termination of the OpenShift routes of the service in values.
+func addRouteValues(name string) {
+	locker.Lock()
+	defer locker.Unlock()
+	if ingress, ok := Values[name]["ingress"].(map[string]interface{}); ok {
+		ingress["route"] = map[string]interface{}{
+			"termination":                   "",
+			"insecureEdgeTerminationPolicy": "Redirect",
+		}
+	}
+}
+
+// ingressValues returns the values of an ingress, without host.
+func ingressValues() map[string]interface{} {
+	return map[string]interface{}{
+		"class":       "nginx",
+		"enabled":     false,
+		"annotations": map[string]string{},
+		"tls": map[string]interface{}{
+			"enabled":    false,
+			"secretName": "",
+			"hosts":      []string{},
+		},
+		"certManager": map[string]interface{}{
+			"issuer":     "",
+			"issuerKind": "ClusterIssuer",
 		},
 	}
-	ingress.SetIngressClass(name)
+}
 
-	return ingress
+// ingressPort returns the port of the service that targets the given container port, and its name. The container
+// port is returned, without name, if the service doesn't target it.
+func ingressPort(port int, ports []*servicePort) (int, string) {
+	for _, sp := range ports {
+		if sp.targetNumber == port && sp.protocol == "TCP" {
+			return sp.port, sp.name
+		}
+	}
+	return port, ""
 }
 
 // Build the selector for the service.
@@ -238,84 +831,362 @@ func buildSelector(name string, s *types.ServiceConfig) map[string]string {
 	}
 }
 
-// buildConfigMapFromPath generates a ConfigMap from a path.
-func buildConfigMapFromPath(name, path string) *helm.ConfigMap {
+// buildStoreFromPath generates a ConfigMap, or a Secret if the kind is "secret", from the files of a path. The
+// path is a file or a directory, the files of the sub-directories are returned as items that give their path in
+// the volume, as the keys cannot contain a slash.
+func buildStoreFromPath(kind, name, path string) (helm.InlineConfig, []map[string]string) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		logger.ActivateColors = true
+		logger.Redf("An error occured reading volume path %s\n", err.Error())
+		logger.ActivateColors = false
+		return nil, nil
+	}
+	files, err := readLocalFiles(path)
+	if err != nil {
+		logger.ActivateColors = true
+		logger.Redf("An error occured reading volume path %s\n", err.Error())
+		logger.ActivateColors = false
+		return nil, nil
+	}
+
+	var store helm.InlineConfig
+	if kind == "secret" {
+		logger.Bluef(ICON_SECRET+" Generating secret from %s\n", GetRelPath(path))
+		store = helm.NewSecret(name, GetRelPath(path))
+	} else {
+		logger.Bluef(ICON_CONF+" Generating configMap from %s\n", GetRelPath(path))
+		store = helm.NewConfigMap(name, GetRelPath(path))
+	}
+	store.Metadata().Name = helm.ReleaseNameTpl + "-" + name + "-" + PathToName(path)
+
+	mapped := false
+	items := make([]map[string]string, 0, len(files))
+	for _, f := range files {
+		if CopyFiles {
+			store.AddChartFile(&helm.ChartFile{
+				Key:    f.key,
+				Path:   chartFilePath(path, f, stat.IsDir()),
+				Source: f.source,
+				Binary: !utf8.Valid(f.content),
+			})
+		} else {
+			store.AddFile(f.key, f.content)
+		}
+		items = append(items, map[string]string{"key": f.key, "path": f.path})
+		mapped = mapped || f.key != f.path
+	}
+	if !mapped {
+		// the keys are the file names
+		items = nil
+	}
+	return store, items
+}
+
+// chartFilePath returns the path of a file of a local volume in the chart, it's in the "files" directory with
+// the path of the volume in the project.
+func chartFilePath(path string, f *localFile, isDir bool) string {
+	base := filepath.ToSlash(filepath.Clean(GetRelPath(path)))
+	base = strings.TrimPrefix(base, "/")
+	if !isDir {
+		return "files/" + base
+	}
+	return "files/" + base + "/" + f.path
+}
+
+// localFile is a file of a local volume, with its key in a ConfigMap or a Secret and its path in the volume.
+type localFile struct {
+	key     string
+	path    string
+	source  string
+	content []byte
+}
+
+// configMapKeyRE matches the characters that are not allowed in the keys of a ConfigMap or a Secret.
+var configMapKeyRE = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// localFileKey returns the key of a file in a ConfigMap or a Secret, from its path in the volume.
+func localFileKey(path string) string {
+	return configMapKeyRE.ReplaceAllString(path, "_")
+}
+
+// readLocalFiles reads the files of a local volume, recursively if it's a directory.
+func readLocalFiles(path string) ([]*localFile, error) {
 	stat, err := os.Stat(path)
 	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []*localFile{{key: localFileKey(filepath.Base(path)), path: filepath.Base(path), source: path, content: content}}, nil
+	}
+
+	files := make([]*localFile, 0)
+	keys := make(map[string]bool)
+	err = filepath.Walk(path, func(f string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if info, err = os.Stat(f); err != nil || !info.Mode().IsRegular() {
+			logger.ActivateColors = true
+			logger.Yellowf("Warning, %s is not a regular file -- skipping\n", GetRelPath(f))
+			logger.ActivateColors = false
+			return nil
+		}
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(path, f)
+		rel = filepath.ToSlash(rel)
+		key := localFileKey(rel)
+		for i := 2; keys[key]; i++ {
+			key = fmt.Sprintf("%s-%d", localFileKey(rel), i)
+		}
+		keys[key] = true
+		files = append(files, &localFile{key: key, path: rel, source: f, content: content})
 		return nil
+	})
+	return files, err
+}
+
+// localFilesSize returns the size of the files in a ConfigMap, or in a Secret if the kind is "secret". The content
+// of the secrets and of the binary files is base64 encoded.
+func localFilesSize(kind string, files []*localFile) int {
+	size := 0
+	for _, f := range files {
+		if kind == "secret" || !utf8.Valid(f.content) {
+			size += base64.StdEncoding.EncodedLen(len(f.content))
+		} else {
+			size += len(f.content)
+		}
 	}
+	return size
+}
 
-	files := make(map[string]string, 0)
-	if stat.IsDir() {
-		found, _ := filepath.Glob(path + "/*")
-		for _, f := range found {
-			if s, err := os.Stat(f); err != nil || s.IsDir() {
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "An error occured reading volume path %s\n", err.Error())
-				} else {
-					logger.ActivateColors = true
-					logger.Yellowf("Warning, %s is a directory, at this time we only "+
-						"can create configmap for first level file list\n", f)
-					logger.ActivateColors = false
+// CheckSizes returns an error if a ConfigMap or a Secret made from files doesn't fit in the 1 MiB limit: the
+// local volumes that are packaged in the chart, and the compose secrets and configs that the services use. It should be
+// called before the chart is written.
+func CheckSizes(project *types.Project) error {
+	for _, s := range project.Services {
+		strategies := localVolumeStrategies(&s)
+		for _, vol := range s.Volumes {
+			strategy, ok := strategies[filepath.Clean(GetRelPath(vol.Source))]
+			if !ok {
+				// the files of the project are packaged in a ConfigMap by default
+				if !strings.HasPrefix(vol.Source, ".") && !strings.HasPrefix(vol.Source, "/") ||
+					GetRelPath(vol.Source) == vol.Source {
+					continue
+				}
+				if stat, err := os.Stat(vol.Source); err != nil || !stat.IsDir() && !stat.Mode().IsRegular() {
+					continue
 				}
+				strategy = "configmap"
+			}
+			if strategy != "configmap" && strategy != "secret" && strategy != "pvc" {
+				continue
+			}
+			kind := "configmap"
+			if strategy == "secret" {
+				kind = "secret"
+			}
+			files, err := readLocalFiles(vol.Source)
+			if err != nil {
+				continue
+			}
+			if size := localFilesSize(kind, files); size > maxConfigMapSize {
+				return localVolumeSizeError(s.Name, vol.Source, kind, size)
+			}
+		}
+
+		objects := make(map[string]types.FileObjectConfig)
+		for _, ref := range s.Secrets {
+			if object, ok := project.Secrets[ref.Source]; ok {
+				objects["secret "+ref.Source] = types.FileObjectConfig(object)
+			}
+		}
+		for _, ref := range s.Configs {
+			if object, ok := project.Configs[ref.Source]; ok {
+				objects["config "+ref.Source] = types.FileObjectConfig(object)
+			}
+		}
+		for name, object := range objects {
+			if object.External.External {
 				continue
 			}
-			_, filename := filepath.Split(f)
-			c, _ := ioutil.ReadFile(f)
-			files[filename] = string(c)
+			var content []byte
+			if inline, ok := object.Extensions[compose.EXT_CONTENT]; ok {
+				content = []byte(fmt.Sprintf("%v", inline))
+			} else if c, err := ioutil.ReadFile(object.File); err == nil {
+				content = c
+			}
+			kind := strings.Split(name, " ")[0]
+			if size := localFilesSize(kind, []*localFile{{content: content}}); size > maxConfigMapSize {
+				return fmt.Errorf("the %s of %s is %d bytes, the limit of a ConfigMap or a Secret is %d bytes",
+					name, s.Name, size, maxConfigMapSize)
+			}
+		}
+	}
+	return nil
+}
+
+// localVolumeSizeError returns the error of a local volume that doesn't fit in a ConfigMap or a Secret.
+func localVolumeSizeError(name, path, kind string, size int) error {
+	return fmt.Errorf("the %s volume of %s is %d bytes in a %s, the limit is %d bytes, "+
+		"use the \"hostPath\" or \"emptyDir\" strategy in the %s label",
+		GetRelPath(path), name, size, kind, maxConfigMapSize, helm.LABEL_LOCAL_VOLS)
+}
+
+// generateContainerPorts add the container ports of a service, from the "ports" and the "expose" sections.
+func generateContainerPorts(s *types.ServiceConfig, name string, container *helm.Container) {
+
+	exists := make(map[string]bool)
+	for _, port := range getPorts(name, s) {
+		// a container port is only declared once, whatever the published ports are
+		key := fmt.Sprintf("%d/%s", port.targetNumber, port.protocol)
+		if exists[key] {
+			continue
+		}
+		exists[key] = true
+		container.Ports = append(container.Ports, &helm.ContainerPort{
+			Name:          portName(port.protocol, port.targetNumber),
+			ContainerPort: port.target,
+			Protocol:      port.protocol,
+		})
+	}
+}
+
+// servicePort is a port of a service, from the "ports" or the "expose" section.
+type servicePort struct {
+	name         string
+	port         int         // the port of the Kubernetes service, it's the published port if it's set
+	target       interface{} // the container port, an int or a template that renders an int
+	targetNumber int
+	protocol     string // TCP, UDP or SCTP
+	published    bool   // true for the "ports" section, the "expose" ports are internal
+}
+
+// getServicePorts returns the ports of the "ports" section. The port of the service is the published port, or the
+// target port if it's not published. Port ranges are already expanded by compose.
+func getServicePorts(s *types.ServiceConfig) []*servicePort {
+	ports := make([]*servicePort, 0)
+	for _, p := range s.Ports {
+		target := int(p.Target)
+		port := target
+		if p.Published != "" {
+			// a range of published ports for one target uses the first one
+			published, err := strconv.Atoi(strings.SplitN(p.Published, "-", 2)[0])
+			if err == nil && published > 0 {
+				port = published
+			}
 		}
+		ports = append(ports, &servicePort{
+			port:         port,
+			target:       portValue(p),
+			targetNumber: target,
+			protocol:     portProtocol(p.Protocol),
+			published:    true,
+		})
 	}
+	return nameServicePorts(ports)
+}
 
-	cm := helm.NewConfigMap(name, GetRelPath(path))
-	cm.Data = files
-	return cm
+// getExposedPorts returns the ports of the "expose" section, as "port[-end][/protocol]".
+func getExposedPorts(name string, s *types.ServiceConfig) []*servicePort {
+	ports := make([]*servicePort, 0)
+	for _, expose := range s.Expose {
+		protocol := "TCP"
+		if i := strings.Index(expose, "/"); i > -1 {
+			protocol = portProtocol(expose[i+1:])
+			expose = expose[:i]
+		}
+		bounds := strings.SplitN(expose, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		end := start
+		if err == nil && len(bounds) == 2 {
+			end, err = strconv.Atoi(bounds[1])
+		}
+		if err != nil || start <= 0 || end < start {
+			logger.ActivateColors = true
+			logger.Redf("The exposed port %s of %s is not valid -- skipping\n", expose, name)
+			logger.ActivateColors = false
+			continue
+		}
+		for port := start; port <= end; port++ {
+			ports = append(ports, &servicePort{port: port, target: port, targetNumber: port, protocol: protocol})
+		}
+	}
+	return nameServicePorts(ports)
 }
 
-// generateContainerPorts add the container ports of a service.
-func generateContainerPorts(s *types.ServiceConfig, name string, container *helm.Container) {
+// getPorts returns the published ports and then the exposed ports, with unique names.
+func getPorts(name string, s *types.ServiceConfig) []*servicePort {
+	return nameServicePorts(append(getServicePorts(s), getExposedPorts(name, s)...))
+}
 
-	exists := make(map[int]string)
-	for _, port := range s.Ports {
-		portName := name
-		for _, n := range exists {
-			if name == n {
-				portName = fmt.Sprintf("%s-%d", name, port.Target)
-			}
-		}
-		container.Ports = append(container.Ports, &helm.ContainerPort{
-			Name:          portName,
-			ContainerPort: int(port.Target),
-		})
-		exists[int(port.Target)] = name
+// portProtocol returns the Kubernetes protocol of a compose port protocol.
+func portProtocol(protocol string) string {
+	switch strings.ToUpper(protocol) {
+	case "UDP":
+		return "UDP"
+	case "SCTP":
+		return "SCTP"
 	}
+	return "TCP"
+}
 
-	// manage the "expose" section to be a NodePort in Kubernetes
-	for _, expose := range s.Expose {
-
-		port, _ := strconv.Atoi(expose)
+// portName returns the name of a port, e.g. "tcp-80". It's a valid port name as it has got at most 15
+// lowercase chars, digits and "-", and at least one letter.
+func portName(protocol string, port int) string {
+	return fmt.Sprintf("%s-%d", strings.ToLower(protocol), port)
+}
 
-		if _, exist := exists[port]; exist {
+// nameServicePorts removes the duplicated ports and gives a unique name to the others.
+func nameServicePorts(ports []*servicePort) []*servicePort {
+	named := make([]*servicePort, 0, len(ports))
+	used := make(map[string]bool)
+	for _, p := range ports {
+		key := fmt.Sprintf("%d/%s", p.port, p.protocol)
+		if used[key] {
 			continue
 		}
-		container.Ports = append(container.Ports, &helm.ContainerPort{
-			Name:          name,
-			ContainerPort: port,
-		})
+		used[key] = true
+
+		base := portName(p.protocol, p.targetNumber)
+		name := base
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+		used[name] = true
+		p.name = name
+		named = append(named, p)
+	}
+	return named
+}
+
+// addServicePorts adds the ports to the Kubernetes service.
+func addServicePorts(ks *helm.Service, ports []*servicePort) {
+	for _, p := range ports {
+		sp := helm.NewServicePort(p.port, p.target)
+		sp.Name = p.name
+		sp.Protocol = p.protocol
+		ks.Spec.Ports = append(ks.Spec.Ports, sp)
 	}
 }
 
-// prepareVolumes add the volumes of a service.
-func prepareVolumes(deployment, name string, s *types.ServiceConfig, container *helm.Container, fileGeneratorChan HelmFileGenerator) []map[string]interface{} {
+// prepareVolumes add the volumes of a service. Named volumes are PersistentVolumeClaims, or claim templates
+// if the deployment is a StatefulSet. Local volumes are packaged following their strategy.
+func prepareVolumes(deployName, name string, s *types.ServiceConfig, container *helm.Container, deployment *helm.Deployment, fileGeneratorChan HelmFileGenerator) []map[string]interface{} {
 
 	volumes := make([]map[string]interface{}, 0)
 	mountPoints := make([]interface{}, 0)
-	configMapsVolumes := make([]string, 0)
-	if v, ok := s.Labels[helm.LABEL_VOL_CM]; ok {
-		configMapsVolumes = strings.Split(v, ",")
-		for i, cm := range configMapsVolumes {
-			configMapsVolumes[i] = strings.TrimSpace(cm)
-		}
-	}
+	strategies := localVolumeStrategies(s)
 
 	for _, vol := range s.Volumes {
 
@@ -329,112 +1200,291 @@ func prepareVolumes(deployment, name string, s *types.ServiceConfig, container *
 			continue
 		}
 
-		isConfigMap := false
-		for _, cmVol := range configMapsVolumes {
-			if GetRelPath(volname) == cmVol {
-				isConfigMap = true
-				break
+		// local volumes are packaged in the chart following their strategy
+		if strings.HasPrefix(volname, ".") || strings.HasPrefix(volname, "/") {
+			strategy, ok := strategies[filepath.Clean(GetRelPath(volname))]
+			if !ok {
+				strategy = defaultLocalStrategy(name, volname)
 			}
-		}
-
-		// local volume cannt be mounted
-		if !isConfigMap && (strings.HasPrefix(volname, ".") || strings.HasPrefix(volname, "/")) {
-			logger.ActivateColors = true
-			logger.Redf("You cannot, at this time, have local volume in %s deployment\n", name)
-			logger.ActivateColors = false
-			continue
-		}
-		if isConfigMap {
-			// check if the volname path points on a file, if so, we need to add subvolume to the interface
-			stat, err := os.Stat(volname)
-			if err != nil {
-				logger.ActivateColors = true
-				logger.Redf("An error occured reading volume path %s\n", err.Error())
-				logger.ActivateColors = false
+			volume, mountPoint := prepareLocalVolume(deployName, name, volname, volepath, strategy, deployment, fileGeneratorChan)
+			if volume == nil {
 				continue
 			}
-			pointToFile := ""
-			if !stat.IsDir() {
-				pointToFile = filepath.Base(volname)
-				volname = filepath.Dir(volname)
-			}
+			volumes = append(volumes, volume)
+			mountPoints = append(mountPoints, mountPoint)
+			continue
+		}
 
-			// the volume is a path and it's explicitally asked to be a configmap in labels
-			cm := buildConfigMapFromPath(name, volname)
-			cm.K8sBase.Metadata.Name = helm.ReleaseNameTpl + "-" + name + "-" + PathToName(volname)
+		// rmove minus sign from volume name
+		volname = strings.ReplaceAll(volname, "-", "")
 
-			// build a configmapRef for this volume
-			volumes = append(volumes, map[string]interface{}{
-				"name": volname,
-				"configMap": map[string]string{
-					"name": cm.K8sBase.Metadata.Name,
-				},
-			})
-			if len(pointToFile) > 0 {
-				mountPoints = append(mountPoints, map[string]interface{}{
-					"name":      volname,
-					"mountPath": volepath,
-					"subPath":   pointToFile,
+		isEmptyDir := false
+		for _, v := range EmptyDirs {
+			v = strings.ReplaceAll(v, "-", "")
+			if v == volname {
+				volumes = append(volumes, map[string]interface{}{
+					"name":     volname,
+					"emptyDir": map[string]string{},
 				})
-			} else {
 				mountPoints = append(mountPoints, map[string]interface{}{
 					"name":      volname,
 					"mountPath": volepath,
 				})
+				container.VolumeMounts = append(container.VolumeMounts, mountPoints...)
+				isEmptyDir = true
+				break
 			}
-			if cm != nil {
-				fileGeneratorChan <- cm
-			}
-		} else {
-			// rmove minus sign from volume name
-			volname = strings.ReplaceAll(volname, "-", "")
-
-			isEmptyDir := false
-			for _, v := range EmptyDirs {
-				v = strings.ReplaceAll(v, "-", "")
-				if v == volname {
-					volumes = append(volumes, map[string]interface{}{
-						"name":     volname,
-						"emptyDir": map[string]string{},
-					})
-					mountPoints = append(mountPoints, map[string]interface{}{
-						"name":      volname,
-						"mountPath": volepath,
-					})
-					container.VolumeMounts = append(container.VolumeMounts, mountPoints...)
-					isEmptyDir = true
-					break
-				}
-			}
-			if isEmptyDir {
-				continue
-			}
+		}
+		if isEmptyDir {
+			continue
+		}
 
-			volumes = append(volumes, map[string]interface{}{
-				"name": volname,
-				"persistentVolumeClaim": map[string]string{
-					"claimName": helm.ReleaseNameTpl + "-" + volname,
-				},
-			})
-			mountPoints = append(mountPoints, map[string]interface{}{
-				"name":      volname,
-				"mountPath": volepath,
-			})
+		mountPoints = append(mountPoints, map[string]interface{}{
+			"name":      volname,
+			"mountPath": volepath,
+		})
+		logger.Yellow(ICON_STORE+" Generate volume values", volname, "for container named", name, "in deployment", deployName)
+		volumes = append(volumes, prepareClaimVolume(deployName, volname, deployment, fileGeneratorChan))
+	}
+	// add the volume in the container and return the volume definition to add in Deployment
+	container.VolumeMounts = append(container.VolumeMounts, mountPoints...)
+	return volumes
+}
 
-			logger.Yellow(ICON_STORE+" Generate volume values", volname, "for container named", name, "in deployment", deployment)
-			AddVolumeValues(deployment, volname, map[string]EnvVal{
-				"enabled":  false,
-				"capacity": "1Gi",
-			})
+// prepareClaimVolume returns the volume of a PersistentVolumeClaim, and sets its values. The claim of a volume
+// shared with other deployments is the one of its owner, and a StatefulSet gets a claim template instead.
+func prepareClaimVolume(deployName, volname string, deployment *helm.Deployment, fileGeneratorChan HelmFileGenerator) map[string]interface{} {
+	// a volume shared with other deployments is configured and created by its owner
+	owner := deployName
+	shared, isShared := sharedVolumes[volname]
+	if isShared && !deployment.IsStatefulSet() {
+		owner = shared.owner
+	}
+	if owner != deployName {
+		if deployment.Spec.Template.Spec.VolumeOwners == nil {
+			deployment.Spec.Template.Spec.VolumeOwners = make(map[string]string)
+		}
+		deployment.Spec.Template.Spec.VolumeOwners[volname] = owner
+		if shared.mode == "affinity" {
+			addPodAffinity(deployment, owner)
+		}
+	} else {
+		accessModes := []string{"ReadWriteOnce"}
+		if isShared && shared.mode == "rwx" {
+			accessModes = []string{"ReadWriteMany"}
+		}
+		volumeValues := map[string]EnvVal{
+			"enabled":       false,
+			"capacity":      "1Gi",
+			"storageClass":  "",
+			"accessModes":   accessModes,
+			"existingClaim": "",
+			"annotations":   map[string]string{},
+		}
+		if deployment.IsStatefulSet() {
+			// claim templates give a claim to each pod, they can't use an existing claim
+			delete(volumeValues, "existingClaim")
+			delete(volumeValues, "annotations")
+		}
+		AddVolumeValues(deployName, volname, volumeValues)
+	}
+
+	if deployment.IsStatefulSet() {
+		// each pod gets its own claim, the emptyDir is only used when the persistence is disabled
+		if !hasClaimTemplate(deployment, volname) {
+			deployment.Spec.VolumeClaimTemplates = append(
+				deployment.Spec.VolumeClaimTemplates,
+				helm.NewVolumeClaimTemplate(deployName, volname),
+			)
+		}
+		return map[string]interface{}{
+			"name":     volname,
+			"emptyDir": map[string]string{},
+		}
+	}
 
-			if pvc := helm.NewPVC(deployment, volname); pvc != nil {
-				fileGeneratorChan <- pvc
+	if owner == deployName {
+		if pvc := helm.NewPVC(deployName, volname); pvc != nil {
+			if isShared {
+				pvc.Deployments = shared.deployments
 			}
+			fileGeneratorChan <- pvc
 		}
 	}
-	// add the volume in the container and return the volume definition to add in Deployment
-	container.VolumeMounts = append(container.VolumeMounts, mountPoints...)
-	return volumes
+	return map[string]interface{}{
+		"name": volname,
+		"persistentVolumeClaim": map[string]string{
+			"claimName": fmt.Sprintf(`{{ .Values.%s.persistence.%s.existingClaim | default (printf "%%s-%s" .Release.Name) }}`,
+				owner, volname, volname),
+		},
+	}
+}
+
+// localVolumeStrategies returns the strategies of the local volumes by path, from the LABEL_LOCAL_VOLS label. The
+// paths of the LABEL_VOL_CM label use the "configmap" strategy.
+func localVolumeStrategies(s *types.ServiceConfig) map[string]string {
+	strategies := make(map[string]string)
+	if v, ok := s.Labels[helm.LABEL_VOL_CM]; ok {
+		for _, path := range strings.Split(v, ",") {
+			strategies[filepath.Clean(strings.TrimSpace(path))] = "configmap"
+		}
+	}
+	v, ok := s.Labels[helm.LABEL_LOCAL_VOLS]
+	if !ok {
+		return strategies
+	}
+	var labelled map[string]string
+	if err := yaml.Unmarshal([]byte(v), &labelled); err != nil {
+		log.Fatalf("The %s label of \"%s\" service is not valid: %s\n", helm.LABEL_LOCAL_VOLS, s.Name, err)
+	}
+	for path, strategy := range labelled {
+		strategy = strings.ToLower(strings.TrimSpace(strategy))
+		switch strategy {
+		case "configmap", "secret", "hostpath", "emptydir", "pvc":
+		default:
+			log.Fatalf("The \"%s\" strategy of the %s volume in \"%s\" service is not valid, use \"configmap\", "+
+				"\"secret\", \"hostPath\", \"emptyDir\" or \"pvc\"\n", strategy, path, s.Name)
+		}
+		strategies[filepath.Clean(path)] = strategy
+	}
+	return strategies
+}
+
+// defaultLocalStrategy returns the strategy of a local volume that has no strategy in labels. The files and
+// directories of the project are packaged in a ConfigMap, the paths outside of the project are mounted from the node.
+func defaultLocalStrategy(name, path string) string {
+	stat, err := os.Stat(path)
+	switch {
+	case GetRelPath(path) == path:
+		logger.ActivateColors = true
+		logger.Yellowf("Warning, the %s volume of %s is outside of the project, it will be mounted from the node\n", path, name)
+		logger.ActivateColors = false
+		return "hostpath"
+	case err != nil:
+		logger.ActivateColors = true
+		logger.Yellowf("Warning, the %s volume of %s does not exist, it will be an emptyDir\n", GetRelPath(path), name)
+		logger.ActivateColors = false
+		return "emptydir"
+	case !stat.IsDir() && !stat.Mode().IsRegular():
+		logger.ActivateColors = true
+		logger.Yellowf("Warning, the %s volume of %s is not a file, it will be mounted from the node\n", GetRelPath(path), name)
+		logger.ActivateColors = false
+		return "hostpath"
+	}
+	files, err := readLocalFiles(path)
+	if err != nil {
+		logger.ActivateColors = true
+		logger.Yellowf("Warning, the %s volume of %s cannot be read, it will be an emptyDir: %s\n", GetRelPath(path), name, err)
+		logger.ActivateColors = false
+		return "emptydir"
+	}
+	if size := localFilesSize("configmap", files); size > maxConfigMapSize {
+		// CheckSizes stops the conversion before
+		log.Fatal(localVolumeSizeError(name, path, "configmap", size))
+	}
+	return "configmap"
+}
+
+// prepareLocalVolume returns the volume and the mount point of a local volume, following its strategy:
+//   - "configmap" and "secret" package the files in a ConfigMap or a Secret,
+//   - "hostpath" mounts the path of the node that is set in values,
+//   - "emptydir" mounts an empty directory,
+//   - "pvc" mounts a PersistentVolumeClaim, an init container copies the files in it if they don't exist.
+func prepareLocalVolume(deployName, name, path, target, strategy string, deployment *helm.Deployment, fileGeneratorChan HelmFileGenerator) (map[string]interface{}, map[string]interface{}) {
+	volname := localVolumeName(path)
+	mountPoint := map[string]interface{}{
+		"name":      volname,
+		"mountPath": target,
+	}
+
+	isFile := false
+	if stat, err := os.Stat(path); err == nil && !stat.IsDir() {
+		isFile = true
+	}
+	if (strategy == "configmap" || strategy == "secret" || strategy == "pvc") && isFile {
+		// the file is mounted alone from the object
+		mountPoint["subPath"] = localFileKey(filepath.Base(path))
+	}
+
+	switch strategy {
+	case "configmap", "secret":
+		store, items := buildStoreFromPath(strategy, name, path)
+		if store == nil {
+			return nil, nil
+		}
+		fileGeneratorChan <- store.(HelmFile)
+		source := map[string]interface{}{}
+		if len(items) > 0 {
+			source["items"] = items
+		}
+		if strategy == "secret" {
+			source["secretName"] = store.Metadata().Name
+			return map[string]interface{}{
+				"name":   volname,
+				"secret": source,
+			}, mountPoint
+		}
+		source["name"] = store.Metadata().Name
+		return map[string]interface{}{
+			"name":      volname,
+			"configMap": source,
+		}, mountPoint
+
+	case "hostpath":
+		logger.Yellow(ICON_STORE+" Generate hostPath value", volname, "for container named", name, "in deployment", deployName)
+		hostPath, _ := filepath.Abs(path)
+		AddNestedValue(deployName, "hostPaths", volname, hostPath)
+		return map[string]interface{}{
+			"name": volname,
+			"hostPath": map[string]string{
+				"path": "{{ .Values." + deployName + ".hostPaths." + volname + " }}",
+			},
+		}, mountPoint
+
+	case "emptydir":
+		return map[string]interface{}{
+			"name":     volname,
+			"emptyDir": map[string]string{},
+		}, mountPoint
+	}
+
+	// the claim is seeded with the files of a ConfigMap
+	store, items := buildStoreFromPath("configmap", name, path)
+	if store == nil {
+		return nil, nil
+	}
+	fileGeneratorChan <- store.(HelmFile)
+	seedSource := map[string]interface{}{"name": store.Metadata().Name}
+	if len(items) > 0 {
+		seedSource["items"] = items
+	}
+	seed := helm.NewContainer("seed-"+volname, "busybox", nil, nil)
+	seed.Command = []string{"sh", "-c", "cp -rLn /katenary/seed/* /katenary/data/"}
+	seed.VolumeMounts = []interface{}{
+		map[string]interface{}{"name": volname + "-seed", "mountPath": "/katenary/seed"},
+		map[string]interface{}{"name": volname, "mountPath": "/katenary/data"},
+	}
+	deployment.Spec.Template.Spec.InitContainers = append(deployment.Spec.Template.Spec.InitContainers, seed)
+	deployment.Spec.Template.Spec.Volumes = append(deployment.Spec.Template.Spec.Volumes, map[string]interface{}{
+		"name":      volname + "-seed",
+		"configMap": seedSource,
+	})
+
+	logger.Yellow(ICON_STORE+" Generate volume values", volname, "for container named", name, "in deployment", deployName)
+	return prepareClaimVolume(deployName, volname, deployment, fileGeneratorChan), mountPoint
+}
+
+// localVolumeNameRE matches the characters that are removed from the name of a local volume.
+var localVolumeNameRE = regexp.MustCompile(`[^a-z0-9]`)
+
+// localVolumeName returns the name of a local volume, it's also its key in values so it's only made of lower
+// case letters and digits. It's suffixed with "local" if a named volume has got the same name.
+func localVolumeName(path string) string {
+	name := localVolumeNameRE.ReplaceAllString(strings.ToLower(PathToName(path)), "")
+	if namedVolumes[name] {
+		name += "local"
+	}
+	return name
 }
 
 // prepareInitContainers add the init containers of a service.
@@ -477,86 +1527,155 @@ func prepareInitContainers(name string, s *types.ServiceConfig, container *helm.
 	return initContainers
 }
 
-// prepareProbes generate http/tcp/command probes for a service.
+// prepareProbes generate http/tcp/command probes for a service. The liveness and readiness probes use the
+// LABEL_HEALTHCHECK label, or the compose healthcheck. If the compose healthcheck has got a "start_period",
+// a startup probe waits for the container instead of delaying the other probes. The LABEL_READINESS and
+// LABEL_STARTUP labels override the readiness and startup probes. Timings are set in values.
 func prepareProbes(name string, s *types.ServiceConfig, container *helm.Container) {
-	// first, check if there a label for the probe
+	// first, check if there a label for the probe, it overrides the compose healthcheck
 	if check, ok := s.Labels[helm.LABEL_HEALTHCHECK]; ok {
-		check = strings.TrimSpace(check)
-		p := helm.NewProbeFromService(s)
-		// get the port of the "url" check
-		if checkurl, err := url.Parse(check); err == nil {
-			if err == nil {
-				container.LivenessProbe = buildProtoProbe(p, checkurl)
-			}
-		} else {
-			// it's a command
-			container.LivenessProbe = p
-			container.LivenessProbe.Exec = &helm.Exec{
-				Command: []string{
-					"sh",
-					"-c",
-					check,
-				},
+		container.LivenessProbe = buildLabelProbe(s, check)
+	} else if s.HealthCheck != nil {
+		container.LivenessProbe = buildCommandProbe(s)
+	}
+
+	if container.LivenessProbe != nil {
+		readiness := *container.LivenessProbe
+		container.ReadinessProbe = &readiness
+		if s.HealthCheck != nil && s.HealthCheck.StartPeriod != nil && *s.HealthCheck.StartPeriod > 0 {
+			container.StartupProbe = buildStartupProbe(container.LivenessProbe, *s.HealthCheck.StartPeriod)
+		}
+	}
+
+	if check, ok := s.Labels[helm.LABEL_READINESS]; ok {
+		container.ReadinessProbe = buildLabelProbe(s, check)
+	}
+	if check, ok := s.Labels[helm.LABEL_STARTUP]; ok {
+		container.StartupProbe = buildLabelProbe(s, check)
+	}
+
+	// the other probes are not delayed as they start after the startup probe
+	if container.StartupProbe != nil {
+		for _, probe := range []*helm.Probe{container.LivenessProbe, container.ReadinessProbe} {
+			if probe != nil {
+				probe.InitialDelay = 0
 			}
 		}
-		return // label overrides everything
 	}
 
-	// if not, we will use the default one
-	if s.HealthCheck != nil {
-		container.LivenessProbe = buildCommandProbe(s)
+	for kind, probe := range map[string]*helm.Probe{
+		"liveness":  container.LivenessProbe,
+		"readiness": container.ReadinessProbe,
+		"startup":   container.StartupProbe,
+	} {
+		if probe != nil {
+			setProbeValues(name, kind, probe)
+		}
 	}
 }
 
-// buildProtoProbe builds a probe from a url that can be http or tcp.
-func buildProtoProbe(probe *helm.Probe, u *url.URL) *helm.Probe {
-	port, err := strconv.Atoi(u.Port())
-	if err != nil {
-		port = 80
+// buildLabelProbe builds a probe from a label value, that is a "http://", "https://" or "tcp://" url, or a command.
+func buildLabelProbe(s *types.ServiceConfig, check string) *helm.Probe {
+	check = strings.TrimSpace(check)
+	p := helm.NewProbeFromService(s)
+	if checkurl, err := url.Parse(check); err == nil {
+		switch checkurl.Scheme {
+		case "http", "https", "tcp":
+			return buildProtoProbe(p, checkurl)
+		}
+	}
+	// it's a command
+	p.Exec = &helm.Exec{
+		Command: []string{
+			"sh",
+			"-c",
+			check,
+		},
 	}
+	return p
+}
 
-	path := "/"
-	if u.Path != "" {
-		path = u.Path
+// buildStartupProbe builds a startup probe that checks the container like the given probe, as long as the
+// compose "start_period" and then the number of retries.
+func buildStartupProbe(probe *helm.Probe, startPeriod types.Duration) *helm.Probe {
+	startup := *probe
+	period, _ := probe.Period.(float64)
+	if period <= 0 {
+		period = 10
 	}
+	failure, _ := probe.Failure.(uint64)
+	startup.InitialDelay = 0
+	startup.Success = 1 // must be 1 for startup probes
+	startup.Failure = uint64(math.Ceil(time.Duration(startPeriod).Seconds()/period)) + failure
+	return &startup
+}
+
+// setProbeValues moves the timings of the probe to the values, in probes.<kind> of the service.
+func setProbeValues(name, kind string, probe *helm.Probe) {
+	AddNestedValue(name, "probes", kind, map[string]EnvVal{
+		"periodSeconds":       probe.Period,
+		"initialDelaySeconds": probe.InitialDelay,
+		"timeoutSeconds":      probe.Timeout,
+		"successThreshold":    probe.Success,
+		"failureThreshold":    probe.Failure,
+	})
+	values := ".Values." + name + ".probes." + kind + "."
+	probe.Period = "{{ " + values + "periodSeconds }}"
+	probe.InitialDelay = "{{ " + values + "initialDelaySeconds }}"
+	probe.Timeout = "{{ " + values + "timeoutSeconds }}"
+	probe.Success = "{{ " + values + "successThreshold }}"
+	probe.Failure = "{{ " + values + "failureThreshold }}"
+}
 
+// buildProtoProbe builds a probe from a url that can be http, https or tcp.
+func buildProtoProbe(probe *helm.Probe, u *url.URL) *helm.Probe {
+	var err error
 	switch u.Scheme {
 	case "http", "https":
-		probe.HttpGet = &helm.HttpGet{
-			Path: path,
-			Port: port,
-		}
+		probe.HttpGet, err = helm.NewHttpGet(u)
 	case "tcp":
-		probe.TCP = &helm.TCP{
-			Port: port,
-		}
+		probe.TCP, err = helm.NewTCP(u)
 	default:
-		logger.Redf("Error while parsing healthcheck url %s\n", u.String())
+		err = fmt.Errorf("unknown scheme %s", u.Scheme)
+	}
+	if err != nil {
+		logger.Redf("Error while parsing healthcheck url %s: %s\n", u.String(), err)
 		os.Exit(1)
 	}
 	return probe
 }
 
+// buildCommandProbe builds a probe from the compose healthcheck, it returns nil if the healthcheck is disabled.
+// The curl, wget, pg_isready and "nc -z" commands are replaced by http or tcp checks, unless LABEL_INFER_PROBES
+// is "false", as the image could not have these commands.
 func buildCommandProbe(s *types.ServiceConfig) *helm.Probe {
-
-	// Get the first element of the command from ServiceConfig
-	first := s.HealthCheck.Test[0]
-
+	if s.HealthCheck.Disable {
+		return nil
+	}
+	exec := helm.NewExecFromHealthCheck(s.HealthCheck.Test)
+	if exec == nil {
+		return nil
+	}
 	p := helm.NewProbeFromService(s)
-	switch first {
-	case "CMD", "CMD-SHELL":
-		// CMD or CMD-SHELL
-		p.Exec = &helm.Exec{
-			Command: s.HealthCheck.Test[1:],
-		}
+	p.Exec = exec
+	if v, ok := s.Labels[helm.LABEL_INFER_PROBES]; ok && strings.TrimSpace(v) == "false" {
 		return p
-	default:
-		// badly made but it should work...
-		p.Exec = &helm.Exec{
-			Command: []string(s.HealthCheck.Test),
-		}
+	}
+
+	httpGet, tcp, err := helm.InferHandler(s.HealthCheck.Test)
+	if err != nil {
+		logger.ActivateColors = true
+		logger.Yellowf("The healthcheck of %s is kept as a command: %s\n", s.Name, err)
+		logger.ActivateColors = false
 		return p
 	}
+	p.Exec, p.HttpGet, p.TCP = nil, httpGet, tcp
+	if httpGet != nil {
+		logger.Greenf("The healthcheck of %s is replaced by an http check on port %d\n", s.Name, httpGet.Port)
+	} else {
+		logger.Greenf("The healthcheck of %s is replaced by a tcp check on port %d\n", s.Name, tcp.Port)
+	}
+	return p
 }
 
 // prepareEnvFromFiles generate configMap or secrets from environment files.
@@ -627,6 +1746,173 @@ func prepareEnvFromFiles(name string, s *types.ServiceConfig, container *helm.Co
 	}
 }
 
+// fileObjectRef is a compose secret or config used by a service.
+type fileObjectRef struct {
+	kind   string // "secret" or "config"
+	ref    types.FileReferenceConfig
+	object types.FileObjectConfig
+	found  bool
+}
+
+// prepareFileObjects mounts the compose secrets and configs of a service as files in the container. Each one
+// becomes a Secret or a ConfigMap of the deployment, or a reference to an existing object if it's "external".
+func prepareFileObjects(deployName string, s *types.ServiceConfig, container *helm.Container, deployment *helm.Deployment, fileGeneratorChan HelmFileGenerator) []map[string]interface{} {
+
+	refs := make([]fileObjectRef, 0)
+	for _, ref := range s.Secrets {
+		object, ok := composeSecrets[ref.Source]
+		refs = append(refs, fileObjectRef{"secret", types.FileReferenceConfig(ref), types.FileObjectConfig(object), ok})
+	}
+	for _, ref := range s.Configs {
+		object, ok := composeConfigs[ref.Source]
+		refs = append(refs, fileObjectRef{"config", types.FileReferenceConfig(ref), types.FileObjectConfig(object), ok})
+	}
+
+	volumes := make([]map[string]interface{}, 0)
+	for _, r := range refs {
+		source := r.ref.Source
+		if !r.found {
+			logger.ActivateColors = true
+			logger.Redf("The %s %s used by %s is not declared at the top level -- skipping\n", r.kind, source, s.Name)
+			logger.ActivateColors = false
+			continue
+		}
+
+		// secrets are in /run/secrets, configs in / by default
+		target := r.ref.Target
+		switch {
+		case r.kind == "secret" && target == "":
+			target = "/run/secrets/" + source
+		case r.kind == "secret" && !filepath.IsAbs(target):
+			target = "/run/secrets/" + target
+		case r.kind == "config" && target == "":
+			target = "/" + source
+		}
+
+		volname := r.kind + "-" + toK8sName(source)
+		objectName := helm.ReleaseNameTpl + "-" + deployName + "-" + toK8sName(source)
+		if r.object.External.External {
+			// the object must exist, its name is set in values
+			section := "externalSecrets"
+			if r.kind == "config" {
+				section = "externalConfigs"
+			}
+			AddNestedValue(deployName, section, source, r.object.Name)
+			objectName = "{{ .Values." + deployName + "." + section + "." + source + " }}"
+		} else if !hasVolume(deployment, volname) {
+			if store := buildFileObject(deployName, r, objectName); store != nil {
+				fileGeneratorChan <- store.(HelmFile)
+			} else {
+				continue
+			}
+		}
+
+		item := map[string]interface{}{
+			"key":  source,
+			"path": source,
+		}
+		if r.ref.Mode != nil {
+			item["mode"] = int(*r.ref.Mode)
+		}
+		volume := map[string]interface{}{"name": volname}
+		if r.kind == "secret" {
+			volume["secret"] = map[string]interface{}{
+				"secretName": objectName,
+				"items":      []interface{}{item},
+			}
+		} else {
+			volume["configMap"] = map[string]interface{}{
+				"name":  objectName,
+				"items": []interface{}{item},
+			}
+		}
+		volumes = append(volumes, volume)
+		container.VolumeMounts = append(container.VolumeMounts, map[string]interface{}{
+			"name":      volname,
+			"mountPath": target,
+			"subPath":   source,
+			"readOnly":  true,
+		})
+
+		// Kubernetes cannot change the owner of a file, but the group can be set to the pod volumes
+		if r.ref.UID != "" {
+			logger.ActivateColors = true
+			logger.Yellowf("Warning, the uid of the %s %s in %s cannot be set in Kubernetes -- ignored\n", r.kind, source, s.Name)
+			logger.ActivateColors = false
+		}
+		if r.ref.GID != "" {
+			gid, err := strconv.Atoi(r.ref.GID)
+			if err != nil {
+				logger.ActivateColors = true
+				logger.Redf("The gid %s of the %s %s in %s is not an integer -- ignored\n", r.ref.GID, r.kind, source, s.Name)
+				logger.ActivateColors = false
+				continue
+			}
+			if deployment.Spec.Template.Spec.SecurityContext == nil {
+				deployment.Spec.Template.Spec.SecurityContext = make(map[string]interface{})
+			}
+			if current, ok := deployment.Spec.Template.Spec.SecurityContext["fsGroup"]; ok && current != gid {
+				logger.ActivateColors = true
+				logger.Yellowf("Warning, the pod of %s already uses the fsGroup %v, the gid %d is ignored\n", deployName, current, gid)
+				logger.ActivateColors = false
+				continue
+			}
+			deployment.Spec.Template.Spec.SecurityContext["fsGroup"] = gid
+		}
+	}
+	return volumes
+}
+
+// buildFileObject builds the Secret or the ConfigMap of a compose secret or config, from its file or inline content.
+func buildFileObject(deployName string, r fileObjectRef, objectName string) helm.InlineConfig {
+	source := r.ref.Source
+	var content []byte
+	if inline, ok := r.object.Extensions[compose.EXT_CONTENT]; ok {
+		content = []byte(fmt.Sprintf("%v", inline))
+	} else {
+		c, err := ioutil.ReadFile(r.object.File)
+		if err != nil {
+			logger.ActivateColors = true
+			logger.Redf("An error occured reading the %s %s: %s -- skipping\n", r.kind, source, err.Error())
+			logger.ActivateColors = false
+			return nil
+		}
+		content = c
+	}
+
+	var store helm.InlineConfig
+	if r.kind == "secret" {
+		logger.Bluef(ICON_SECRET+" Generating secret from %s secret\n", source)
+		store = helm.NewSecret(deployName, "")
+	} else {
+		logger.Bluef(ICON_CONF+" Generating configMap from %s config\n", source)
+		store = helm.NewConfigMap(deployName, "")
+	}
+	store.Metadata().Name = objectName
+	store.AddFile(source, content)
+	return store
+}
+
+// hasClaimTemplate returns true if the StatefulSet has already got the named claim template.
+func hasClaimTemplate(deployment *helm.Deployment, name string) bool {
+	for _, c := range deployment.Spec.VolumeClaimTemplates {
+		if c.Metadata.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasVolume returns true if the deployment has already got the named volume.
+func hasVolume(deployment *helm.Deployment, name string) bool {
+	for _, v := range deployment.Spec.Template.Spec.Volumes {
+		if v["name"] == name {
+			return true
+		}
+	}
+	return false
+}
+
 // AddValues adds values to the values.yaml map.
 func AddValues(servicename string, values map[string]EnvVal) {
 	locker.Lock()
@@ -641,6 +1927,20 @@ func AddValues(servicename string, values map[string]EnvVal) {
 	}
 }
 
+// AddNestedValue adds a value in a section of the values.yaml map for the given service.
+func AddNestedValue(servicename, section, key string, value EnvVal) {
+	locker.Lock()
+	defer locker.Unlock()
+
+	if _, ok := Values[servicename]; !ok {
+		Values[servicename] = make(map[string]interface{})
+	}
+	if _, ok := Values[servicename][section]; !ok {
+		Values[servicename][section] = make(map[string]EnvVal)
+	}
+	Values[servicename][section].(map[string]EnvVal)[key] = value
+}
+
 // AddVolumeValues add a volume to the values.yaml map for the given deployment name.
 func AddVolumeValues(deployment string, volname string, values map[string]EnvVal) {
 	locker.Lock()
@@ -685,6 +1985,11 @@ func applyEnvMapLabel(s *types.ServiceConfig, c *helm.Container) {
 	// the mapenv is a YAML string
 	var envmap map[string]EnvVal
 	err := yaml.Unmarshal([]byte(mapenv), &envmap)
+	if err != nil {
+		// values starting with a template (e.g. "{{ .Release.Name }}-db") are not valid YAML,
+		// so we read them line by line as "KEY: value"
+		envmap, err = readEnvMapLines(mapenv)
+	}
 	if err != nil {
 		logger.ActivateColors = true
 		logger.Red(err.Error())
@@ -712,6 +2017,22 @@ func applyEnvMapLabel(s *types.ServiceConfig, c *helm.Container) {
 	}
 }
 
+// readEnvMapLines reads a mapenv label content that is made of "KEY: value" lines.
+func readEnvMapLines(mapenv string) (map[string]EnvVal, error) {
+	envmap := make(map[string]EnvVal)
+	for _, line := range strings.Split(mapenv, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("the line %q in %s label is not valid", line, helm.LABEL_MAP_ENV)
+		}
+		envmap[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return envmap, nil
+}
+
 // setEnvToValues will set the environment variables to the values.yaml map.
 func setEnvToValues(name string, s *types.ServiceConfig, c *helm.Container) {
 	// crete the "environment" key
@@ -759,7 +2080,7 @@ func setSecretVar(name string, s *types.ServiceConfig, c *helm.Container) *helm.
 			continue
 		}
 		// add the secret
-		store.AddEnv(secretvar, ".Values."+name+".environment."+secretvar)
+		store.AddEnv(secretvar, "tpl .Values."+name+".environment."+secretvar+" .")
 		for i, env := range c.Env {
 			if env.Name == secretvar {
 				c.Env = append(c.Env[:i], c.Env[i+1:]...)
@@ -808,7 +2129,12 @@ func newContainerForDeployment(deployName, containerName string, deployment *hel
 	// and then we can add other volumes
 	deployment.Spec.Template.Spec.Volumes = append(
 		deployment.Spec.Template.Spec.Volumes,
-		prepareVolumes(deployName, containerName, s, container, fileGeneratorChan)...,
+		prepareVolumes(deployName, containerName, s, container, deployment, fileGeneratorChan)...,
+	)
+	// compose secrets and configs are mounted as files
+	deployment.Spec.Template.Spec.Volumes = append(
+		deployment.Spec.Template.Spec.Volumes,
+		prepareFileObjects(deployName, s, container, deployment, fileGeneratorChan)...,
 	)
 
 	// add init containers
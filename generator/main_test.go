@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/compose-spec/compose-go/cli"
+	"gopkg.in/yaml.v3"
 )
 
 const DOCKER_COMPOSE_YML = `version: '3'
@@ -64,6 +65,7 @@ services:
             - data:/var/lib/mysql
         labels:
             katenary.io/ports: 3306
+            katenary.io/workload: deployment
 
 
     # try to deploy 2 services but one is in the same pod than the other
@@ -96,6 +98,14 @@ services:
         env_file:
           - config/env
 
+    # service in a profile
+    adminer:
+        image: adminer
+        ports:
+          - "8080:8080"
+        profiles:
+          - tools
+
 volumes:
     data:
 `
@@ -110,8 +120,8 @@ func init() {
 
 func setUp(t *testing.T) (string, *compose.Parser) {
 
-	// cleanup "made" files
-	helm.ResetMadePVC()
+	// cleanup "made" files and values
+	resetGenerator()
 
 	cli.DefaultFileNames = defaultCliFiles
 
@@ -127,7 +137,7 @@ func setUp(t *testing.T) (string, *compose.Parser) {
 	}
 
 	composefile := filepath.Join(tmpwork, "docker-compose.yaml")
-	p := compose.NewParser(composefile, DOCKER_COMPOSE_YML)
+	p := compose.NewParser([]string{composefile}, DOCKER_COMPOSE_YML)
 
 	// create envfile for "useenvfile" service
 	err = os.Mkdir(filepath.Join(tmpwork, "config"), 0777)
@@ -148,7 +158,7 @@ func setUp(t *testing.T) (string, *compose.Parser) {
 
 	p.Parse("testapp")
 
-	Generate(p, "test-0", "testapp", "1.2.3", "4.5.6", DOCKER_COMPOSE_YML, tmp)
+	Generate(p, "test-0", "testapp", "1.2.3", "4.5.6", p.Files, tmp)
 
 	return tmp, p
 }
@@ -162,6 +172,65 @@ func tearDown() {
 	}
 }
 
+// resetGenerator cleans the state that the generator keeps between two charts.
+func resetGenerator() {
+	helm.ResetMadePVC()
+	Values = make(map[string]map[string]interface{})
+	VolumeValues = make(map[string]map[string]map[string]EnvVal)
+	EmptyDirs = []string{}
+	servicesMap = make(map[string]int)
+}
+
+// chartOption prepares the working directory, or the parser, before the compose file is parsed.
+type chartOption func(t *testing.T, workdir string, p *compose.Parser)
+
+// withFile writes a file in the working directory, the path is relative to the compose file.
+func withFile(name, content string) chartOption {
+	return func(t *testing.T, workdir string, p *compose.Parser) {
+		path := filepath.Join(workdir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// withOverride adds a compose file that overrides the first one.
+func withOverride(name, content string) chartOption {
+	return func(t *testing.T, workdir string, p *compose.Parser) {
+		withFile(name, content)(t, workdir, p)
+		p.Files = append(p.Files, filepath.Join(workdir, name))
+	}
+}
+
+// generateChart parses the given compose file and generates its chart, the temporary directories are removed at the
+// end of the test. It returns the chart directory and the parser.
+func generateChart(t *testing.T, content string, opts ...chartOption) (string, *compose.Parser) {
+	resetGenerator()
+
+	tmp, err := os.MkdirTemp(os.TempDir(), "katenary-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+	tmpwork, err := os.MkdirTemp(os.TempDir(), "katenary-test-work-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpwork) })
+
+	p := compose.NewParser([]string{filepath.Join(tmpwork, "compose.yaml")}, content)
+	for _, opt := range opts {
+		opt(t, tmpwork, p)
+	}
+	p.Parse("testapp")
+	Generate(p, "test-0", "testapp", "1.2.3", "4.5.6", p.Files, tmp)
+
+	return tmp, p
+}
+
 // Check if the web2 service has got a command.
 func TestCommand(t *testing.T) {
 	tmp, p := setUp(t)
@@ -395,3 +464,1291 @@ func TestEqualSignOnEnv(t *testing.T) {
 		}
 	}
 }
+
+// Check that services in a profile are disabled by default.
+func TestProfiles(t *testing.T) {
+	tmp, p := setUp(t)
+	defer tearDown()
+
+	content, err := ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]map[string]interface{})
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, service := range p.Data.Services {
+		name := service.Name
+		if _, found := service.Labels[helm.LABEL_SAMEPOD]; found {
+			continue
+		}
+		expected := name != "adminer"
+		if values[name]["enabled"] != expected {
+			t.Errorf("%s service should have enabled set to %v, got %v", name, expected, values[name]["enabled"])
+		}
+	}
+
+	// every object must be conditionned
+	path := filepath.Join(tmp, "templates", "adminer.service.yaml")
+	service, _ := ioutil.ReadFile(path)
+	if !strings.HasPrefix(string(service), "{{- if .Values.adminer.enabled }}") {
+		t.Error("adminer service should be conditionned by the enabled value")
+		t.Log(string(service))
+	}
+}
+
+// Check that several compose files are merged in order.
+func TestOverrideFiles(t *testing.T) {
+	tmp, p := generateChart(t, `
+services:
+    web:
+        image: nginx:1.21
+        ports:
+            - "80:80"
+`, withOverride("compose.prod.yaml", `
+services:
+    web:
+        image: nginx:1.23
+`))
+	if len(p.Files) != 2 {
+		t.Fatal("The parser should keep the 2 compose files, got", p.Files)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "tag: \"1.23\"") {
+		t.Error("The override file should change the image tag to 1.23")
+		t.Log(string(content))
+	}
+}
+
+// Check that compose variables are kept as values.
+func TestKeepVariables(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    vars:
+        image: nginx:${TAG:-1.23}
+        environment:
+            SECRET: ${SECRET:?the secret is required}
+            OTHER: ${OTHER}
+        ports:
+            - "${PORT:-8080}"
+`, func(t *testing.T, workdir string, p *compose.Parser) { p.KeepVariables = true })
+
+	content, err := ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{"TAG": "1.23", "SECRET": "", "OTHER": "", "PORT": "8080"} {
+		if values[name] != expected {
+			t.Errorf("%s value should be %q, got %v", name, expected, values[name])
+		}
+	}
+	service := values["vars"].(map[string]interface{})
+	if tag := service["repository"].(map[string]interface{})["tag"]; tag != "{{ .Values.TAG }}" {
+		t.Error("The image tag should point on the TAG value, got", tag)
+	}
+	env := service["environment"].(map[string]interface{})
+	if env["SECRET"] != `{{ required "the secret is required" .Values.SECRET }}` {
+		t.Error("The SECRET variable should be required, got", env["SECRET"])
+	}
+
+	deployment, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "vars.deployment.yaml"))
+	if !strings.Contains(string(deployment), "containerPort: {{ .Values.PORT }}\n") {
+		t.Error("The container port should point on the PORT value")
+		t.Log(string(deployment))
+	}
+}
+
+// Check that the escaped "$$" are unescaped once when variables are kept.
+func TestKeepEscapedDollar(t *testing.T) {
+	tmp, p := generateChart(t, `
+services:
+    vars:
+        image: registry.local/$$app:${TAG:-1.23}
+        environment:
+            FOO: "$$HOME/x"
+`, func(t *testing.T, workdir string, p *compose.Parser) { p.KeepVariables = true })
+
+	if _, ok := p.Variables["HOME"]; ok {
+		t.Error("The escaped HOME variable should not be kept as value")
+	}
+	content, err := ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		t.Fatal(err)
+	}
+	service := values["vars"].(map[string]interface{})
+	if image := service["repository"].(map[string]interface{})["image"]; image != "registry.local/$app" {
+		t.Error("The image should keep the dollar sign, got", image)
+	}
+	env := service["environment"].(map[string]interface{})
+	if env["FOO"] != "$HOME/x" {
+		t.Error("The FOO variable should keep the dollar sign, got", env["FOO"])
+	}
+}
+
+// Check that compose secrets and configs are mounted from Secrets and ConfigMaps.
+func TestSecretsAndConfigs(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    app:
+        image: nginx
+        secrets:
+            - db_password
+            - source: api_key
+              mode: 0400
+              gid: "1000"
+        configs:
+            - source: app_config
+              target: /etc/app.conf
+secrets:
+    db_password:
+        file: ./password.txt
+    api_key:
+        external: true
+configs:
+    app_config:
+        content: |
+            debug = true
+`, withFile("password.txt", "secret"))
+
+	secret, err := ioutil.ReadFile(filepath.Join(tmp, "templates", "app-db-password-secret.secret.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(secret), "db_password: c2VjcmV0") {
+		t.Error("The secret should contain the base64 encoded file")
+		t.Log(string(secret))
+	}
+	config, err := ioutil.ReadFile(filepath.Join(tmp, "templates", "app-app-config-configmap.configmap.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(config), "debug = true") {
+		t.Error("The configMap should contain the inline content")
+		t.Log(string(config))
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "templates", "app-api-key-secret.secret.yaml")); err == nil {
+		t.Error("The external secret should not be generated")
+	}
+
+	deployment, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "app.deployment.yaml"))
+	for _, expected := range []string{
+		"mountPath: /run/secrets/db_password",
+		"mountPath: /etc/app.conf",
+		"secretName: '{{ .Values.app.externalSecrets.api_key }}'",
+		"mode: 256",
+		"fsGroup: 1000",
+	} {
+		if !strings.Contains(string(deployment), expected) {
+			t.Errorf("The deployment should contain %q", expected)
+		}
+	}
+}
+
+// Check that the x-katenary extension is used instead of labels.
+func TestExtension(t *testing.T) {
+	tmp, p := generateChart(t, `
+services:
+    web:
+        image: nginx
+        labels:
+            katenary.io/ports: 8080
+        x-katenary:
+            ports: [80]
+            ingress: 80
+            mapenv:
+                DB_HOST: "{{ .Release.Name }}-database"
+        environment:
+            DB_HOST: database
+x-katenary:
+    empty-dirs: [cache]
+`)
+	labels := p.Data.Services[0].Labels
+	if labels[helm.LABEL_PORT] != "80" {
+		t.Error("The extension should take precedence over the labels, got", labels[helm.LABEL_PORT])
+	}
+	if labels[helm.LABEL_EMPTYDIRS] != "cache" {
+		t.Error("The top-level extension should set the default options, got", labels[helm.LABEL_EMPTYDIRS])
+	}
+
+	if _, err := os.Stat(filepath.Join(tmp, "templates", "web.ingress.yaml")); err != nil {
+		t.Error("The ingress should be generated from the extension", err)
+	}
+	values, _ := ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	if !strings.Contains(string(values), "DB_HOST: '{{ .Release.Name }}-database'") {
+		t.Error("The environment should be mapped from the extension")
+		t.Log(string(values))
+	}
+}
+
+// Check that databases with named volumes are deployed as StatefulSet.
+func TestStatefulSet(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    db:
+        image: postgres:14
+        ports:
+            - 5432
+        volumes:
+            - pgdata:/var/lib/postgresql/data
+    app:
+        image: myapp
+        volumes:
+            - appdata:/data
+        labels:
+            katenary.io/workload: statefulset
+    cache:
+        image: redis
+volumes:
+    pgdata:
+    appdata:
+`)
+
+	for _, name := range []string{"db", "app"} {
+		content, err := ioutil.ReadFile(filepath.Join(tmp, "templates", name+".statefulset.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sts := string(content)
+		if !strings.Contains(sts, "serviceName: '{{ .Release.Name }}-"+name+"-headless'") {
+			t.Error("The statefulset should use the headless service")
+			t.Log(sts)
+		}
+		if !strings.Contains(sts, "volumeClaimTemplates:") {
+			t.Error("The statefulset should have volume claim templates")
+			t.Log(sts)
+		}
+		if _, err := os.Stat(filepath.Join(tmp, "templates", name+"-headless.service.yaml")); err != nil {
+			t.Error("The headless service should be generated", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "templates", "db-pgdata.pvc.yaml")); err == nil {
+		t.Error("A statefulset should not have a standalone pvc")
+	}
+	// redis has no named volume, it stays a deployment
+	if _, err := os.Stat(filepath.Join(tmp, "templates", "cache.deployment.yaml")); err != nil {
+		t.Error(err)
+	}
+
+	values := make(map[string]map[string]interface{})
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	if _, ok := values["db"]["persistence"].(map[string]interface{})["pgdata"]; !ok {
+		t.Error("The persistence of the volume should be in values")
+	}
+}
+
+// Check that services in global mode are deployed as DaemonSet.
+func TestDaemonSet(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    exporter:
+        image: prom/node-exporter
+        deploy:
+            mode: global
+        healthcheck:
+            test: ["CMD", "wget", "-q", "-O-", "http://localhost:9100"]
+    shipper:
+        image: fluent/fluent-bit
+        labels:
+            katenary.io/workload: daemonset
+`)
+
+	for _, name := range []string{"exporter", "shipper"} {
+		content, err := ioutil.ReadFile(filepath.Join(tmp, "templates", name+".daemonset.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds := string(content)
+		for _, expected := range []string{
+			"kind: DaemonSet",
+			"{{- toYaml .Values." + name + ".tolerations | nindent 8 }}",
+			"{{- toYaml .Values." + name + ".updateStrategy | nindent 4 }}",
+		} {
+			if !strings.Contains(ds, expected) {
+				t.Errorf("The daemonset should contain %q", expected)
+				t.Log(ds)
+			}
+		}
+		if strings.Contains(ds, "replicas:") {
+			t.Error("A daemonset has no replicas")
+		}
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "exporter.daemonset.yaml"))
+	if !strings.Contains(string(content), "livenessProbe:") {
+		t.Error("The probe should be kept in the daemonset")
+	}
+}
+
+// Check that services with a schedule are CronJobs.
+func TestCronJob(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    backup:
+        image: postgres:14
+        command: ["pg_dump", "-f", "/backup/dump.sql"]
+        ports:
+            - "5432"
+        labels:
+            katenary.io/ingress: 5432
+        volumes:
+            - backup:/backup
+        x-katenary:
+            cronjob: "0 2 * * *"
+    web:
+        image: nginx
+        depends_on:
+            - backup
+volumes:
+    backup:
+`)
+
+	content, err := ioutil.ReadFile(filepath.Join(tmp, "templates", "backup.cronjob.yaml"))
+	if err != nil {
+		list, _ := filepath.Glob(tmp + "/templates/*")
+		t.Log(list)
+		t.Fatal(err)
+	}
+	cronjob := string(content)
+	for _, expected := range []string{
+		"kind: CronJob",
+		"schedule: '{{ .Values.backup.cronjob.schedule }}'",
+		"suspend: {{ .Values.backup.cronjob.suspend }}",
+		"failedJobsHistoryLimit: {{ .Values.backup.cronjob.failedJobsHistoryLimit }}",
+		"restartPolicy: OnFailure",
+		"{{- if  .Values.backup.persistence.backup.enabled }}",
+	} {
+		if !strings.Contains(cronjob, expected) {
+			t.Errorf("The cronjob should contain %q", expected)
+			t.Log(cronjob)
+		}
+	}
+	for _, kind := range []string{"deployment", "service", "ingress"} {
+		if _, err := os.Stat(filepath.Join(tmp, "templates", "backup."+kind+".yaml")); err == nil {
+			t.Errorf("A cronjob should not have a %s", kind)
+		}
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "web.deployment.yaml"))
+	if strings.Contains(string(content), "check-backup") {
+		t.Error("A service should not wait for a cronjob")
+		t.Log(string(content))
+	}
+
+	values := make(map[string]map[string]interface{})
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	if schedule := values["backup"]["cronjob"].(map[string]interface{})["schedule"]; schedule != "0 2 * * *" {
+		t.Error("The schedule should be in values, got", schedule)
+	}
+}
+
+// Check that the replicas are set in values and can be managed by an autoscaler.
+func TestReplicas(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    web:
+        image: nginx
+        deploy:
+            replicas: 3
+    worker:
+        image: busybox
+        scale: 2
+    single:
+        image: busybox
+`)
+
+	values := make(map[string]map[string]interface{})
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	for name, expected := range map[string]int{"web": 3, "worker": 2, "single": 1} {
+		if replicas := values[name]["replicaCount"]; replicas != expected {
+			t.Errorf("The %s replicaCount should be %d, got %v", name, expected, replicas)
+		}
+		if _, ok := values[name]["autoscaling"].(map[string]interface{})["enabled"]; !ok {
+			t.Errorf("The %s autoscaling should be in values", name)
+		}
+	}
+
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "web.deployment.yaml"))
+	if !strings.Contains(string(content), "{{- if not .Values.web.autoscaling.enabled }}\n  replicas: {{ .Values.web.replicaCount }}") {
+		t.Error("The replicas should be set from values when the autoscaling is disabled")
+		t.Log(string(content))
+	}
+	content, err := ioutil.ReadFile(filepath.Join(tmp, "templates", "web.hpa.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "maxReplicas: {{ .Values.web.autoscaling.maxReplicas }}") {
+		t.Error("The autoscaler should use the values")
+		t.Log(string(content))
+	}
+}
+
+// Check that the compose resources are converted to Kubernetes quantities in values.
+func TestResources(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    web:
+        image: nginx
+        deploy:
+            resources:
+                limits:
+                    cpus: "0.5"
+                    memory: 512M
+                reservations:
+                    cpus: "2"
+                    memory: 1G
+    legacy:
+        image: nginx
+        cpus: 1.5
+        mem_limit: 256m
+        mem_reservation: 64k
+`)
+
+	values := make(map[string]map[string]interface{})
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	for name, expected := range map[string]map[string]map[string]interface{}{
+		"web": {
+			"limits":   {"cpu": "500m", "memory": "512Mi"},
+			"requests": {"cpu": "2", "memory": "1Gi"},
+		},
+		"legacy": {
+			"limits":   {"cpu": "1500m", "memory": "256Mi"},
+			"requests": {"memory": "64Ki"},
+		},
+	} {
+		resources, _ := values[name]["resources"].(map[string]interface{})
+		for section, quantities := range expected {
+			got, _ := resources[section].(map[string]interface{})
+			for k, v := range quantities {
+				if got[k] != v {
+					t.Errorf("The %s %s %s should be %v, got %v", name, section, k, v, got[k])
+				}
+			}
+		}
+	}
+
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "web.deployment.yaml"))
+	if !strings.Contains(string(content), "{{- toYaml .Values.web.resources | nindent 12 }}") {
+		t.Error("The container resources should be set from values")
+		t.Log(string(content))
+	}
+}
+
+// Check the readiness and startup probes, from the healthcheck and from labels.
+func TestProbes(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    web:
+        image: nginx
+        healthcheck:
+            test: ["CMD", "curl", "-f", "http://localhost"]
+            interval: 5s
+            retries: 2
+            start_period: 30s
+    api:
+        image: myapi
+        labels:
+            katenary.io/healthcheck: http://localhost:8080/health
+            katenary.io/readiness: http://localhost:8080/ready
+            katenary.io/startup: tcp://localhost:8080
+    shell:
+        image: myapi
+        healthcheck:
+            test: test -f /tmp/ready || exit 1
+            timeout: 5s
+    nocheck:
+        image: myapi
+        healthcheck:
+            disable: true
+    curl:
+        image: myapi
+        healthcheck:
+            test: ["CMD", "curl", "-f", "http://localhost:8080/health"]
+    keepcurl:
+        image: myapi
+        healthcheck:
+            test: ["CMD", "curl", "-f", "http://localhost:8080/health"]
+        labels:
+            katenary.io/infer-probes: "false"
+`)
+
+	for _, name := range []string{"web", "api"} {
+		content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", name+".deployment.yaml"))
+		for _, probe := range []string{"liveness", "readiness", "startup"} {
+			expected := "failureThreshold: {{ .Values." + name + ".probes." + probe + ".failureThreshold }}"
+			if !strings.Contains(string(content), probe+"Probe:") || !strings.Contains(string(content), expected) {
+				t.Errorf("The %s deployment should have a %s probe with values", name, probe)
+				t.Log(string(content))
+			}
+		}
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "api.deployment.yaml"))
+	if !strings.Contains(string(content), "path: /ready") {
+		t.Error("The readiness label should be used")
+		t.Log(string(content))
+	}
+
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "shell.deployment.yaml"))
+	if !strings.Contains(string(content), "- sh\n                - -c\n                - test -f /tmp/ready || exit 1") {
+		t.Error("The shell healthcheck should be run with sh -c")
+		t.Log(string(content))
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "curl.deployment.yaml"))
+	if !strings.Contains(string(content), "httpGet:\n              path: /health\n              port: 8080") || strings.Contains(string(content), "exec:") {
+		t.Error("The curl healthcheck should be an http check")
+		t.Log(string(content))
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "keepcurl.deployment.yaml"))
+	if !strings.Contains(string(content), "exec:") || strings.Contains(string(content), "httpGet:") {
+		t.Error("The curl healthcheck should be kept as a command")
+		t.Log(string(content))
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "nocheck.deployment.yaml"))
+	if strings.Contains(string(content), "Probe:") {
+		t.Error("A disabled healthcheck should not give probes")
+		t.Log(string(content))
+	}
+
+	values := make(map[string]map[string]interface{})
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	if timeout := values["shell"]["probes"].(map[string]interface{})["liveness"].(map[string]interface{})["timeoutSeconds"]; timeout != 5 {
+		t.Error("The timeout should be set in values, got", timeout)
+	}
+	probes := values["web"]["probes"].(map[string]interface{})
+	startup := probes["startup"].(map[string]interface{})
+	// 30s of start period every 5s, and then 2 retries
+	if startup["failureThreshold"] != 8 || startup["periodSeconds"] != 5 {
+		t.Error("The startup probe should wait for the start period, got", startup)
+	}
+	if delay := probes["liveness"].(map[string]interface{})["initialDelaySeconds"]; delay != 0 {
+		t.Error("The liveness probe should not be delayed when there is a startup probe, got", delay)
+	}
+}
+
+// Check that services use the published and target ports, the protocols and unique port names.
+func TestPortMapping(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    dns:
+        image: coredns/coredns
+        ports:
+            - 8080:80/udp
+            - 8081:80
+            - 3000-3002:3000-3002
+        expose:
+            - 53/udp
+            - 9000-9001
+`)
+
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "dns.service.yaml"))
+	for _, expected := range []string{
+		"- name: udp-80\n      protocol: UDP\n      port: 8080\n      targetPort: 80",
+		"- name: tcp-80\n      protocol: TCP\n      port: 8081\n      targetPort: 80",
+		"- name: tcp-3002\n      protocol: TCP\n      port: 3002\n      targetPort: 3002",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The service should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+
+	for _, expected := range []string{"name: udp-53\n      protocol: UDP", "name: tcp-9000", "name: tcp-9001"} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The exposed ports should be in the service, %q not found", expected)
+			t.Log(string(content))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "templates", "dns-external.service.yaml")); err == nil {
+		t.Error("The exposed ports should not give another service")
+	}
+
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "dns.deployment.yaml"))
+	if strings.Count(string(content), "containerPort: 80\n") != 2 || !strings.Contains(string(content), "- name: udp-80\n") {
+		t.Error("The container should have one port 80 for each protocol")
+		t.Log(string(content))
+	}
+}
+
+// Check that the type and the options of the service are taken from values.
+func TestServiceValues(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    web:
+        image: nginx
+        ports:
+            - 8080:80
+        expose:
+            - 9090
+`)
+
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "web.service.yaml"))
+	for _, expected := range []string{
+		"type: {{ .Values.web.service.type }}",
+		"{{- if .Values.web.service.headless }}\n  clusterIP: None",
+		"{{- range $k, $v := .Values.web.service.annotations }}",
+		"loadBalancerIP: {{ . }}",
+		"externalTrafficPolicy: {{ .Values.web.service.externalTrafficPolicy }}",
+		`nodePort: {{ index .Values.web.service.nodePorts "tcp-80" }}`,
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The service should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+	if strings.Contains(string(content), `"tcp-9090"`) {
+		t.Error("An exposed port should not get a node port")
+	}
+
+	values := make(map[string]map[string]interface{})
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	service := values["web"]["service"].(map[string]interface{})
+	if service["type"] != "ClusterIP" || service["headless"] != false {
+		t.Error("The service should be a ClusterIP by default, got", service)
+	}
+	if nodePorts := service["nodePorts"].(map[string]interface{}); len(nodePorts) != 1 {
+		t.Error("Only the published port should get a node port value, got", nodePorts)
+	}
+}
+
+// Check the ingress rules with several hosts, paths and ports.
+func TestIngressRules(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    web:
+        image: nginx
+        ports:
+            - 8080:80
+            - 9000
+        labels:
+            katenary.io/ingress: |
+                - host: www.example.com
+                  paths:
+                    - port: 80
+                    - path: /admin
+                      pathType: Exact
+                      port: 9000
+    api:
+        image: myapi
+        ports:
+            - 8000
+        x-katenary:
+            ingress:
+                - paths:
+                    - path: /api
+                      port: 8000
+`)
+
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "web.ingress.yaml"))
+	for _, expected := range []string{
+		"host: '{{ (index .Values.web.ingress.rules 0).host }}'",
+		"path: '{{ (index (index .Values.web.ingress.rules 0).paths 1).path }}'",
+		"pathType: Exact",
+		"number: 8080",
+		"servicePort: 9000",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The ingress should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+	if strings.Count(string(content), "{{- else }}\n              serviceName:") != 2 || strings.Count(string(content), "servicePort:") != 2 {
+		t.Error("Each backend should have its condition for old Kubernetes versions")
+		t.Log(string(content))
+	}
+
+	values := make(map[string]map[string]interface{})
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	rules := values["api"]["ingress"].(map[string]interface{})["rules"].([]interface{})
+	rule := rules[0].(map[string]interface{})
+	if rule["host"] != "api.testapp.tld" {
+		t.Error("The default host should be set in values, got", rule["host"])
+	}
+	if path := rule["paths"].([]interface{})[0].(map[string]interface{})["path"]; path != "/api" {
+		t.Error("The path should be set in values, got", path)
+	}
+}
+
+// Check the TLS section and the cert-manager annotations of the ingress.
+func TestIngressTLS(t *testing.T) {
+	tmp, _ := setUp(t)
+	defer tearDown()
+
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "web.ingress.yaml"))
+	for _, expected := range []string{
+		"{{- if .Values.web.ingress.tls.enabled }}\n  tls:",
+		`- secretName: {{ .Values.web.ingress.tls.secretName | default (printf "%s-web-tls" .Release.Name) }}`,
+		"{{- else }}\n        - {{ .Values.web.ingress.host }}",
+		"cert-manager.io/cluster-issuer: {{ . }}",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The ingress should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+
+	values := make(map[string]map[string]interface{})
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	ingress := values["web"]["ingress"].(map[string]interface{})
+	if tls := ingress["tls"].(map[string]interface{}); tls["enabled"] != false {
+		t.Error("The TLS should be disabled by default, got", tls)
+	}
+	if cm := ingress["certManager"].(map[string]interface{}); cm["issuer"] != "" || cm["issuerKind"] != "ClusterIssuer" {
+		t.Error("The cert-manager issuer should be empty by default, got", cm)
+	}
+
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "NOTES.txt"))
+	if !strings.Contains(string(content), "http{{ if .Values.web.ingress.tls.enabled }}s{{ end }}://") {
+		t.Error("The notes should use https when TLS is enabled")
+		t.Log(string(content))
+	}
+}
+
+// Check that the services of a shared ingress are merged in one ingress, with a path for each service.
+func TestSharedIngress(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    front:
+        image: nginx
+        ports: [80]
+        labels:
+            katenary.io/shared-ingress: site
+    api:
+        image: myapi
+        ports: ["8080:3000"]
+        x-katenary:
+            shared-ingress: site/api
+`)
+
+	for _, name := range []string{"front", "api"} {
+		if _, err := os.Stat(filepath.Join(tmp, "templates", name+".ingress.yaml")); err == nil {
+			t.Errorf("The %s service should not have its own ingress", name)
+		}
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "site.ingress.yaml"))
+	for _, expected := range []string{
+		"host: '{{ .Values.site.ingress.host }}'",
+		"{{- if .Values.api.enabled }}\n          - path: '{{ index .Values.site.ingress.paths \"api\" }}'",
+		"{{- if .Values.front.enabled }}\n          - path: '{{ index .Values.site.ingress.paths \"front\" }}'",
+		"servicePort: 8080",
+		"name: '{{ .Release.Name }}-front'",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The shared ingress should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+
+	values := make(map[string]map[string]interface{})
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	ingress := values["site"]["ingress"].(map[string]interface{})
+	if ingress["host"] != "site.testapp.tld" || values["site"]["enabled"] != true {
+		t.Error("The shared ingress values should be set in the group, got", values["site"])
+	}
+	if paths := ingress["paths"].(map[string]interface{}); paths["api"] != "/api" || paths["front"] != "/" {
+		t.Error("The paths should be set in values, got", paths)
+	}
+
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "NOTES.txt"))
+	if !strings.Contains(string(content), `- api is accessible on : http{{ if .Values.site.ingress.tls.enabled }}s{{ end }}://{{ .Values.site.ingress.host }}{{ index .Values.site.ingress.paths "api" }}`) {
+		t.Error("The notes should give the URL of the api on the shared host")
+		t.Log(string(content))
+	}
+}
+
+// Check that a shared ingress named after a service doesn't override the values of the service.
+func TestSharedIngressServiceName(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    front:
+        image: nginx
+        ports: [80]
+        labels:
+            katenary.io/shared-ingress: front
+            katenary.io/ingress: 80
+    api:
+        image: myapi
+        ports: [3000]
+        labels:
+            katenary.io/shared-ingress: front/api
+`)
+
+	if _, err := os.Stat(filepath.Join(tmp, "templates", "sharedfront.ingress.yaml")); err != nil {
+		t.Error("The shared ingress should be renamed", err)
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "sharedfront.ingress.yaml"))
+	if !strings.Contains(string(content), "host: '{{ .Values.sharedfront.ingress.host }}'") {
+		t.Error("The shared ingress should use the renamed values")
+		t.Log(string(content))
+	}
+
+	values := make(map[string]map[string]interface{})
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	if paths := values["sharedfront"]["ingress"].(map[string]interface{})["paths"].(map[string]interface{}); paths["front"] != "/" || paths["api"] != "/api" {
+		t.Error("The paths should be set in the renamed values, got", paths)
+	}
+	if ingress, ok := values["front"]["ingress"].(map[string]interface{}); ok {
+		if _, ok := ingress["paths"]; ok {
+			t.Error("The values of the front service should not get the shared ingress, got", ingress)
+		}
+	}
+}
+
+// Check that the ingress labels give Gateway API routes with the "gateway" ingress kind.
+func TestHTTPRoute(t *testing.T) {
+	IngressKinds = []string{"gateway"}
+	defer func() { IngressKinds = []string{"ingress"} }()
+	tmp, _ := setUp(t)
+	defer tearDown()
+
+	if _, err := os.Stat(filepath.Join(tmp, "templates", "web.ingress.yaml")); err == nil {
+		t.Error("The ingress should not be generated with the gateway kind")
+	}
+	content, err := ioutil.ReadFile(filepath.Join(tmp, "templates", "web.httproute.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"{{- if and .Values.web.enabled .Values.web.ingress.enabled }}",
+		"kind: HTTPRoute",
+		"- name: '{{ .Values.web.ingress.gateway.name }}'",
+		"{{- with .Values.web.ingress.gateway.namespace }}",
+		"hostnames:\n    - '{{ .Values.web.ingress.host }}'",
+		"type: PathPrefix\n            value: /",
+		"- name: '{{ .Release.Name }}-web'\n          port: 80",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The route should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+
+	values := make(map[string]map[string]interface{})
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	gateway := values["web"]["ingress"].(map[string]interface{})["gateway"].(map[string]interface{})
+	if gateway["name"] != "gateway" {
+		t.Error("The parent gateway should be set in values, got", gateway)
+	}
+}
+
+// Check that the ingress labels give OpenShift routes with the "route" ingress kind.
+func TestRoute(t *testing.T) {
+	IngressKinds = []string{"ingress", "route"}
+	defer func() { IngressKinds = []string{"ingress"} }()
+	tmp, _ := setUp(t)
+	defer tearDown()
+
+	if _, err := os.Stat(filepath.Join(tmp, "templates", "web.ingress.yaml")); err != nil {
+		t.Error("The ingress should be generated alongside the route")
+	}
+	content, err := ioutil.ReadFile(filepath.Join(tmp, "templates", "web.route.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"apiVersion: route.openshift.io/v1\nkind: Route",
+		"host: '{{ .Values.web.ingress.host }}'",
+		"{{- if ne .Values.web.ingress.route.termination \"passthrough\" }}\n  path: /",
+		"to:\n    kind: Service\n    name: '{{ .Release.Name }}-web'",
+		"targetPort: tcp-80",
+		"{{- with .Values.web.ingress.route.termination }}\n  tls:\n    termination: {{ . }}",
+		"insecureEdgeTerminationPolicy: {{ $.Values.web.ingress.route.insecureEdgeTerminationPolicy }}",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The route should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+
+	values := make(map[string]map[string]interface{})
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	route := values["web"]["ingress"].(map[string]interface{})["route"].(map[string]interface{})
+	if route["termination"] != "" || route["insecureEdgeTerminationPolicy"] != "Redirect" {
+		t.Error("The route should not terminate TLS by default, got", route)
+	}
+}
+
+// Check the storage class, access modes, existing claim and annotations of the persistent volume claims.
+func TestPVCValues(t *testing.T) {
+	tmp, _ := setUp(t)
+	defer tearDown()
+
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "database-data.pvc.yaml"))
+	for _, expected := range []string{
+		"(not .Values.database.persistence.data.existingClaim) }}",
+		"{{- toYaml .Values.database.persistence.data.accessModes | nindent 4 }}",
+		"{{- with .Values.database.persistence.data.storageClass }}\n  storageClassName: {{ if eq . \"-\" }}\"\"{{ else }}{{ . }}{{ end }}",
+		"{{- range $k, $v := .Values.database.persistence.data.annotations }}",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The claim should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "database.deployment.yaml"))
+	if !strings.Contains(string(content), `claimName: '{{ .Values.database.persistence.data.existingClaim | default (printf "%s-data" .Release.Name) }}'`) {
+		t.Error("The deployment should mount the existing claim if it's set")
+		t.Log(string(content))
+	}
+
+	values := make(map[string]map[string]interface{})
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	data := values["database"]["persistence"].(map[string]interface{})["data"].(map[string]interface{})
+	for _, key := range []string{"storageClass", "accessModes", "existingClaim", "annotations"} {
+		if _, ok := data[key]; !ok {
+			t.Errorf("The %s of the volume should be in values", key)
+		}
+	}
+}
+
+// Check that a named volume mounted by several deployments gets only one claim.
+func TestSharedVolumes(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    web:
+        image: nginx
+        volumes:
+            - shared-data:/usr/share/nginx/html
+            - logs:/var/log/nginx
+    worker:
+        image: busybox
+        volumes:
+            - shared-data:/data
+        labels:
+            katenary.io/volume-sharing: affinity
+volumes:
+    shared-data:
+    logs:
+`)
+
+	if _, err := os.Stat(filepath.Join(tmp, "templates", "worker-shareddata.pvc.yaml")); err == nil {
+		t.Error("The worker should not create a claim for the shared volume")
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "templates", "web-shareddata.pvc.yaml")); err != nil {
+		t.Error("The web deployment should own the claim of the shared volume")
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "worker.deployment.yaml"))
+	for _, expected := range []string{
+		"{{- if  .Values.web.persistence.shareddata.enabled }}",
+		`claimName: '{{ .Values.web.persistence.shareddata.existingClaim | default (printf "%s-shareddata" .Release.Name) }}'`,
+		"podAffinity:",
+		"katenary.io/component: web",
+		"topologyKey: kubernetes.io/hostname",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The worker deployment should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+
+	values := make(map[string]map[string]interface{})
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "values.yaml"))
+	yaml.Unmarshal(content, &values)
+	if _, ok := values["worker"]["persistence"]; ok {
+		t.Error("The worker should not have persistence values, got", values["worker"]["persistence"])
+	}
+	persistence := values["web"]["persistence"].(map[string]interface{})
+	// with affinity, the pods are on the same node, the claim can stay ReadWriteOnce
+	if modes := persistence["shareddata"].(map[string]interface{})["accessModes"].([]interface{}); modes[0] != "ReadWriteOnce" {
+		t.Error("The shared volume should be ReadWriteOnce with affinity, got", modes)
+	}
+	if modes := persistence["logs"].(map[string]interface{})["accessModes"].([]interface{}); modes[0] != "ReadWriteOnce" {
+		t.Error("The logs volume should be ReadWriteOnce, got", modes)
+	}
+}
+
+// Check that the local volumes are packaged following their strategy.
+func TestLocalVolumes(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    app:
+        image: nginx
+        volumes:
+            - ./conf:/etc/app
+            - ./conf/app.ini:/etc/app.ini
+            - ./certs:/certs
+            - ./data:/data
+            - ./cache:/cache
+            - /var/run/docker.sock:/var/run/docker.sock
+        x-katenary:
+            local-volumes:
+                ./certs: secret
+                ./data: pvc
+`,
+		withFile("conf/app.ini", "debug = true\n"),
+		withFile("certs/tls.key", "key"),
+		withFile("data/seed.txt", "seed"),
+	)
+
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "app.deployment.yaml"))
+	for _, expected := range []string{
+		"configMap:\n            name: '{{ .Release.Name }}-app-conf'\n          name: conf",
+		"mountPath: /etc/app.ini\n              name: confappini\n              subPath: app.ini",
+		"secret:\n            secretName: '{{ .Release.Name }}-app-certs'",
+		"{{- if  .Values.app.persistence.data.enabled }}",
+		"- name: seed-data",
+		"name: data-seed",
+		"emptyDir: {}\n          name: cache",
+		"path: '{{ .Values.app.hostPaths.varrundockersock }}'",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The deployment should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+
+	secret, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "app-certs-secretcerts.secret.yaml"))
+	if !strings.Contains(string(secret), "tls.key: a2V5") {
+		t.Error("The secret should contain the base64 encoded files")
+		t.Log(string(secret))
+	}
+	config, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "app-conf-app.ini-configmapconf-app.ini.configmap.yaml"))
+	if !strings.Contains(string(config), "app.ini: |\n    debug = true") {
+		t.Error("The configMap of a file should contain the file")
+		t.Log(string(config))
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "templates", "app-data.pvc.yaml")); err != nil {
+		t.Error("The seeded volume should have a claim")
+	}
+}
+
+// Check that the ConfigMap of a local volume has got the nested and binary files, and that the size is checked.
+func TestConfigMapFiles(t *testing.T) {
+	tmp, p := generateChart(t, `
+services:
+    app:
+        image: nginx
+        volumes:
+            - ./conf:/etc/app
+`,
+		withFile("conf/app.ini", "debug = true\n"),
+		withFile("conf/sites/enabled/default.conf", "listen 80;\n"),
+		withFile("conf/cert.der", "\x30\x82\xff\x00"),
+	)
+	if err := CheckSizes(p.Data); err != nil {
+		t.Error("The volume should fit in a ConfigMap, got", err)
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "app-conf-configmapconf.configmap.yaml"))
+	for _, expected := range []string{
+		"sites_enabled_default.conf: |\n    listen 80;",
+		"binaryData:\n  cert.der: MIL/AA==",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The configMap should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "app.deployment.yaml"))
+	if !strings.Contains(string(content), "- key: sites_enabled_default.conf\n                path: sites/enabled/default.conf") {
+		t.Error("The nested files should be mapped to their path")
+		t.Log(string(content))
+	}
+
+	tmpwork := filepath.Dir(p.Files[0])
+	ioutil.WriteFile(filepath.Join(tmpwork, "conf", "big.txt"), []byte(strings.Repeat("a", 1024*1024)), 0644)
+	p = compose.NewParser([]string{filepath.Join(tmpwork, "compose.yaml")}, `
+services:
+    app:
+        image: nginx
+        volumes:
+            - ./conf:/etc/app
+        labels:
+            katenary.io/local-volumes: "./conf: configmap"
+`)
+	p.Parse("testapp")
+	if err := CheckSizes(p.Data); err == nil {
+		t.Error("The volume should be too big for a ConfigMap")
+	}
+
+	// the volumes without strategy are also packaged in a ConfigMap
+	p = compose.NewParser([]string{filepath.Join(tmpwork, "compose.yaml")}, `
+services:
+    app:
+        image: nginx
+        volumes:
+            - ./conf:/etc/app
+`)
+	p.Parse("testapp")
+	if err := CheckSizes(p.Data); err == nil {
+		t.Error("The volume without strategy should be too big for a ConfigMap")
+	}
+
+	// the compose configs must also fit in a ConfigMap
+	ioutil.WriteFile(filepath.Join(tmpwork, "big.conf"), []byte(strings.Repeat("a", 1024*1024+1)), 0644)
+	p = compose.NewParser([]string{filepath.Join(tmpwork, "compose.yaml")}, `
+services:
+    app:
+        image: nginx
+        configs:
+            - big
+configs:
+    big:
+        file: ./big.conf
+`)
+	p.Parse("testapp")
+	if err := CheckSizes(p.Data); err == nil {
+		t.Error("The config should be too big for a ConfigMap")
+	}
+}
+
+// Check that the files of the local volumes are copied in the chart with the "copy files" option.
+func TestCopyFiles(t *testing.T) {
+	CopyFiles = true
+	defer func() { CopyFiles = false }()
+	tmp, _ := generateChart(t, `
+services:
+    app:
+        image: nginx
+        volumes:
+            - ./conf:/etc/nginx
+            - ./php:/usr/local/etc/php
+`,
+		withFile("conf/nginx.conf", "server {}\n"),
+		withFile("conf/page.tpl", "{{ title }}\n"),
+		withFile("php/php.ini", "memory_limit = 1G\n"),
+		withFile("php/conf.d/opcache.ini", "opcache.enable = 1\n"),
+	)
+
+	for _, f := range []string{"conf/nginx.conf", "conf/page.tpl", "php/php.ini", "php/conf.d/opcache.ini"} {
+		if _, err := os.Stat(filepath.Join(tmp, "files", f)); err != nil {
+			t.Errorf("The %s file should be copied in the chart", f)
+		}
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "app-conf-configmapconf.configmap.yaml"))
+	if !strings.Contains(string(content), "data:\n  {{- (.Files.Glob \"files/conf/*\").AsConfig | nindent 2 }}") {
+		t.Error("The configMap of a directory should load its files with a glob")
+		t.Log(string(content))
+	}
+	if strings.Contains(string(content), "{{ title }}") {
+		t.Error("The content of the files should not be in the template")
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "app-php-configmapphp.configmap.yaml"))
+	for _, expected := range []string{
+		"php.ini: {{ .Files.Get \"files/php/php.ini\" | quote }}",
+		"conf.d_opcache.ini: {{ .Files.Get \"files/php/conf.d/opcache.ini\" | quote }}",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The configMap should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+}
+
+// Check that the files with characters that are not allowed in keys are mounted with their name.
+func TestConfigMapKeys(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    app:
+        image: nginx
+        volumes:
+            - ./conf:/etc/app
+            - ./my app.ini:/etc/app.ini
+`,
+		withFile("conf/my file.conf", "a = 1\n"),
+		withFile("my app.ini", "b = 2\n"),
+	)
+
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "app.deployment.yaml"))
+	for _, expected := range []string{
+		"- key: my_file.conf\n                path: my file.conf",
+		"mountPath: /etc/app.ini\n              name: myappini\n              subPath: my_app.ini",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The deployment should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+}
+
+// Check that the owner of a shared volume is enabled by default, and that the claim is kept if one of the
+// deployments is enabled.
+func TestSharedVolumeOwner(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    admin:
+        image: adminer
+        profiles: [tools]
+        volumes:
+            - uploads:/uploads
+    web:
+        image: nginx
+        volumes:
+            - uploads:/uploads
+    worker:
+        image: busybox
+        volumes:
+            - uploads:/uploads
+        labels:
+            katenary.io/volume-sharing: affinity
+volumes:
+    uploads:
+`)
+
+	content, err := ioutil.ReadFile(filepath.Join(tmp, "templates", "web-uploads.pvc.yaml"))
+	if err != nil {
+		t.Fatal("The web deployment should own the claim as admin is disabled by default")
+	}
+	if !strings.Contains(string(content), "{{ if and (or .Values.admin.enabled .Values.web.enabled .Values.worker.enabled) ") {
+		t.Error("The claim should be created if one of the deployments is enabled")
+		t.Log(string(content))
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(tmp, "templates", "worker.deployment.yaml"))
+	if !strings.Contains(string(content), "{{- if and .Values.web.enabled }}\n      affinity:") {
+		t.Error("The pod affinity should only be set if the owner is enabled")
+		t.Log(string(content))
+	}
+}
+
+// Check that a local volume doesn't replace a named volume with the same name.
+func TestLocalVolumeNames(t *testing.T) {
+	tmp, _ := generateChart(t, `
+services:
+    app:
+        image: nginx
+        volumes:
+            - data:/var/lib/app
+            - ./data:/docker-entrypoint-initdb.d
+volumes:
+    data:
+`, withFile("data/init.sql", "SELECT 1;\n"))
+
+	content, _ := ioutil.ReadFile(filepath.Join(tmp, "templates", "app.deployment.yaml"))
+	for _, expected := range []string{
+		"mountPath: /var/lib/app\n              name: data\n",
+		"mountPath: /docker-entrypoint-initdb.d\n              name: datalocal\n",
+		"persistentVolumeClaim:",
+		"name: '{{ .Release.Name }}-app-data'\n          name: datalocal",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The deployment should contain %q", expected)
+			t.Log(string(content))
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package generator
 
 import (
+	"fmt"
 	"katenary/compose"
+	"math"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -20,3 +23,37 @@ func PathToName(path string) string {
 	path = regexp.MustCompile(replaceChars).ReplaceAllString(path, "-")
 	return path
 }
+
+// toK8sName transforms a compose name to a valid Kubernetes object name.
+func toK8sName(name string) string {
+	name = strings.ToLower(name)
+	name = regexp.MustCompile(`[^a-z0-9.-]`).ReplaceAllString(name, "-")
+	return strings.Trim(name, "-.")
+}
+
+// cpuQuantity transforms a compose CPU number (e.g. "0.5") to a Kubernetes quantity (e.g. "500m").
+func cpuQuantity(cpus string) (string, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(cpus), 64)
+	if err != nil || f < 0 {
+		return "", fmt.Errorf("%q is not a valid number of CPUs", cpus)
+	}
+	milli := int64(math.Round(f * 1000))
+	if milli%1000 == 0 {
+		return strconv.FormatInt(milli/1000, 10), nil
+	}
+	return strconv.FormatInt(milli, 10) + "m", nil
+}
+
+// memoryQuantity transforms a compose memory size, in bytes, to a Kubernetes quantity. Compose units are
+// binary, so 512M is 512Mi.
+func memoryQuantity(bytes int64) string {
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"Gi", 1 << 30}, {"Mi", 1 << 20}, {"Ki", 1 << 10}} {
+		if bytes >= unit.size && bytes%unit.size == 0 {
+			return strconv.FormatInt(bytes/unit.size, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(bytes, 10)
+}
@@ -1,9 +1,11 @@
 package generator
 
 import (
+	"fmt"
 	"katenary/compose"
 	"katenary/generator/writers"
 	"katenary/helm"
+	"katenary/logger"
 	"log"
 	"os"
 	"path/filepath"
@@ -39,7 +41,7 @@ func portExists(port int, ports []types.ServicePortConfig) bool {
 }
 
 // Generate get a parsed compose file, and generate the helm files.
-func Generate(p *compose.Parser, katernayVersion, appName, appVersion, chartVersion, composeFile, dirName string) {
+func Generate(p *compose.Parser, katernayVersion, appName, appVersion, chartVersion string, composeFiles []string, dirName string) {
 
 	// make the appname global (yes... ugly but easy)
 	helm.Appname = appName
@@ -54,6 +56,10 @@ func Generate(p *compose.Parser, katernayVersion, appName, appVersion, chartVers
 
 	generators := make(map[string]HelmFileGenerator)
 
+	// services can use the top-level secrets and configs
+	composeSecrets = p.Data.Secrets
+	composeConfigs = p.Data.Configs
+
 	// remove skipped services from the parsed data
 	for i, service := range p.Data.Services {
 		if v, ok := service.Labels[helm.LABEL_IGNORE]; !ok || v != "true" {
@@ -71,6 +77,20 @@ func Generate(p *compose.Parser, katernayVersion, appName, appVersion, chartVers
 	for i, service := range p.Data.Services {
 		n := service.Name
 
+		// warn if a service waits for another one that is disabled by default (profiles)
+		if isEnabledByProfiles(&service) {
+			for dp := range service.DependsOn {
+				for _, s := range p.Data.Services {
+					if s.Name == dp && !isEnabledByProfiles(&s) {
+						logger.ActivateColors = true
+						logger.Yellowf("Warning, %s depends on %s which is disabled by default, "+
+							"you will need to enable it in values\n", n, dp)
+						logger.ActivateColors = false
+					}
+				}
+			}
+		}
+
 		// if the service port is declared in labels, add it to the service.
 		if ports, ok := service.Labels[helm.LABEL_PORT]; ok {
 			if service.Ports == nil {
@@ -89,12 +109,24 @@ func Generate(p *compose.Parser, katernayVersion, appName, appVersion, chartVers
 				})
 			}
 		}
-		// find port and store it in servicesMap
-		for _, port := range service.Ports {
-			target := int(port.Target)
-			if target != 0 {
-				servicesMap[n] = target
-				break
+		// a cronjob has no Service, so the other services cannot wait for it
+		if _, ok := service.Labels[helm.LABEL_CRONJOB]; ok {
+			for _, service2 := range p.Data.Services {
+				if _, ok := service2.DependsOn[n]; ok {
+					logger.ActivateColors = true
+					logger.Yellowf("Warning, %s depends on %s which is a cronjob, the dependency is ignored\n",
+						service2.Name, n)
+					logger.ActivateColors = false
+					delete(service2.DependsOn, n)
+				}
+			}
+		} else {
+			// find the port of the service and store it in servicesMap
+			for _, port := range getPorts(n, &service) {
+				if port.port != 0 {
+					servicesMap[n] = port.port
+					break
+				}
 			}
 		}
 
@@ -112,6 +144,18 @@ func Generate(p *compose.Parser, katernayVersion, appName, appVersion, chartVers
 
 	}
 
+	// named volumes can be mounted by several deployments
+	sharedVolumes = findSharedVolumes(p.Data.Services)
+
+	// the local volumes must not have the name of a named volume
+	namedVolumes = make(map[string]bool)
+	for name := range p.Data.Volumes {
+		namedVolumes[strings.ReplaceAll(name, "-", "")] = true
+	}
+
+	// the services add their paths to the shared ingresses, it must be reset before to start the generators
+	sharedIngresses = make(map[string][]*sharedIngressPath)
+
 	// for all services in linked map, and not in samePods map, generate the service
 	for _, s := range p.Data.Services {
 		name := s.Name
@@ -145,9 +189,9 @@ func Generate(p *compose.Parser, katernayVersion, appName, appVersion, chartVers
 			kind = strings.ToLower(kind)
 
 			// Add a SHA inside the generated file, it's only
-			// to make it easy to check it the compose file corresponds to the
+			// to make it easy to check it the compose files correspond to the
 			// generated helm chart
-			helmFile.(helm.Signable).BuildSHA(composeFile)
+			helmFile.(helm.Signable).BuildSHA(composeFiles)
 
 			// Some types need special fixes in yaml generation
 			switch c := helmFile.(type) {
@@ -161,6 +205,14 @@ func Generate(p *compose.Parser, katernayVersion, appName, appVersion, chartVers
 				// either we use an "emptyDir"
 				writers.BuildDeployment(c, n, templatesDir)
 
+			case *helm.CronJob:
+				// the cronjob runs the pod of the deployment, with the same fixes for volumes
+				writers.BuildCronJob(c, n, templatesDir)
+
+			case *helm.HorizontalPodAutoscaler:
+				// the autoscaler is only created if it's enabled in values
+				writers.BuildHPA(c, n, templatesDir)
+
 			case *helm.Service:
 				// Change the type for service if it's an "exposed" port
 				writers.BuildService(c, n, templatesDir)
@@ -168,7 +220,7 @@ func Generate(p *compose.Parser, katernayVersion, appName, appVersion, chartVers
 			case *helm.Ingress:
 				// we need to make ingresses "activable" from values
 				ingresses[n] = c // keep it to generate notes
-				writers.BuildIngress(c, n, templatesDir)
+				writeIngress(c, n, templatesDir)
 
 			case *helm.ConfigMap, *helm.Secret:
 				// there could be several files, so let's force the filename
@@ -192,15 +244,37 @@ func Generate(p *compose.Parser, katernayVersion, appName, appVersion, chartVers
 			}
 		}
 	}
+	// the shared ingresses get the paths of all the services
+	for group, ingress := range buildSharedIngresses() {
+		ingress.BuildSHA(composeFiles)
+		ingresses[group] = ingress
+		writeIngress(ingress, group, templatesDir)
+	}
+
 	// Create the values.yaml file
 	valueFile, err := os.Create(filepath.Join(dirName, "values.yaml"))
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer valueFile.Close()
+	// compose variables kept as values are at the top level
+	values := make(map[string]interface{})
+	for name, v := range p.Variables {
+		if v.Templated {
+			values[name] = v.Default
+		}
+	}
+	for name, v := range Values {
+		if _, ok := values[name]; ok {
+			logger.ActivateColors = true
+			logger.Yellowf("Warning, the %s variable has got the same name as a service, it's overridden in values\n", name)
+			logger.ActivateColors = false
+		}
+		values[name] = v
+	}
 	enc := yaml.NewEncoder(valueFile)
 	enc.SetIndent(writers.IndentSize)
-	enc.Encode(Values)
+	enc.Encode(values)
 
 	// Create tht Chart.yaml file
 	chartFile, err := os.Create(filepath.Join(dirName, "Chart.yaml"))
@@ -229,3 +303,31 @@ func Generate(p *compose.Parser, katernayVersion, appName, appVersion, chartVers
 	defer noteFile.Close()
 	noteFile.WriteString(helm.GenerateNotesFile(ingresses))
 }
+
+// writeIngress writes the ingress, or the objects that replace it, following the IngressKinds.
+func writeIngress(ingress *helm.Ingress, name, templatesDir string) {
+	for _, kind := range IngressKinds {
+		switch kind {
+		case "gateway":
+			addGatewayValues(name)
+			for i, route := range helm.NewHTTPRoutesFromIngress(name, ingress) {
+				fname := name
+				if i > 0 {
+					fname = fmt.Sprintf("%s-%d", name, i)
+				}
+				writers.BuildHTTPRoute(route, name, fname, templatesDir)
+			}
+		case "route":
+			addRouteValues(name)
+			for i, route := range helm.NewRoutesFromIngress(name, ingress) {
+				fname := name
+				if i > 0 {
+					fname = fmt.Sprintf("%s-%d", name, i)
+				}
+				writers.BuildRoute(route, name, fname, templatesDir)
+			}
+		default:
+			writers.BuildIngress(ingress, name, templatesDir)
+		}
+	}
+}
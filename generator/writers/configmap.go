@@ -1,18 +1,106 @@
 package writers
 
 import (
+	"bytes"
+	"io/ioutil"
+	"katenary/helm"
+	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
-// BuildConfigMap writes the configMap.
+// BuildConfigMap writes the configMap. If the configMap, or the secret, has got files that are copied in the chart,
+// they are copied and loaded with .Files.
 func BuildConfigMap(c interface{}, kind, servicename, name, templatesDir string) {
 	fname := filepath.Join(templatesDir, name+"."+kind+".yaml")
 	fp, _ := os.Create(fname)
-	enc := yaml.NewEncoder(fp)
+	fp.WriteString(enabledCondition(servicename))
+	buffer := bytes.NewBuffer(nil)
+	enc := yaml.NewEncoder(buffer)
 	enc.SetIndent(IndentSize)
 	enc.Encode(c)
+
+	var files []*helm.ChartFile
+	secret := false
+	switch c := c.(type) {
+	case *helm.ConfigMap:
+		files = c.Files
+	case *helm.Secret:
+		files = c.Files
+		secret = true
+	}
+	if len(files) == 0 {
+		fp.WriteString(buffer.String())
+	} else {
+		for _, line := range strings.Split(buffer.String(), "\n") {
+			if line == "" || line == "data: {}" {
+				continue
+			}
+			fp.WriteString(line + "\n")
+		}
+		fp.WriteString(chartFilesData(files, secret))
+		copyChartFiles(files, filepath.Dir(templatesDir))
+	}
+	fp.WriteString("{{- end }}")
 	fp.Close()
 }
+
+// chartFilesData returns the data of a configMap or a secret that loads the files of the chart. The files of a
+// directory are loaded with a glob, so the files that are added in the chart are also loaded, else each file is
+// loaded by its path.
+func chartFilesData(files []*helm.ChartFile, secret bool) string {
+	indent := strings.Repeat(" ", IndentSize)
+	dir := path.Dir(files[0].Path)
+	glob := len(files) > 1
+	for _, f := range files {
+		if path.Dir(f.Path) != dir || dir+"/"+f.Key != f.Path || (f.Binary && !secret) {
+			glob = false
+		}
+	}
+	if glob {
+		as := "AsConfig"
+		if secret {
+			as = "AsSecrets"
+		}
+		return "data:\n" + indent + "{{- (.Files.Glob \"" + dir + "/*\")." + as + " | nindent " + strconv.Itoa(IndentSize) + " }}\n"
+	}
+
+	data := "data:\n"
+	binaryData := ""
+	for _, f := range files {
+		switch {
+		case secret:
+			data += indent + f.Key + ": {{ .Files.Get \"" + f.Path + "\" | b64enc }}\n"
+		case f.Binary:
+			binaryData += indent + f.Key + ": {{ .Files.Get \"" + f.Path + "\" | b64enc }}\n"
+		default:
+			data += indent + f.Key + ": {{ .Files.Get \"" + f.Path + "\" | quote }}\n"
+		}
+	}
+	if binaryData != "" {
+		data += "binaryData:\n" + binaryData
+	}
+	return data
+}
+
+// copyChartFiles copies the files in the chart directory.
+func copyChartFiles(files []*helm.ChartFile, chartDir string) {
+	for _, f := range files {
+		content, err := ioutil.ReadFile(f.Source)
+		if err != nil {
+			log.Fatalf("Cannot read %s to copy it in the chart: %s", f.Source, err)
+		}
+		dest := filepath.Join(chartDir, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			log.Fatalf("Cannot create the %s directory of the chart: %s", filepath.Dir(dest), err)
+		}
+		if err := ioutil.WriteFile(dest, content, 0644); err != nil {
+			log.Fatalf("Cannot write %s in the chart: %s", dest, err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package writers
+
+import (
+	"bytes"
+	"katenary/helm"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BuildCronJob writes the cronjob.
+func BuildCronJob(cronjob *helm.CronJob, name, templatesDir string) {
+	kind := "cronjob"
+	fname := filepath.Join(templatesDir, name+"."+kind+".yaml")
+	fp, _ := os.Create(fname)
+	buffer := bytes.NewBuffer(nil)
+	enc := yaml.NewEncoder(buffer)
+	enc.SetIndent(IndentSize)
+	enc.Encode(cronjob)
+	fp.WriteString(enabledCondition(name))
+	writePodLines(fp, strings.Split(buffer.String(), "\n"), cronjob.Metadata.Labels[helm.K+"/component"], nil,
+		&cronjob.Spec.JobTemplate.Spec.Template.Spec)
+	fp.WriteString("{{- end }}")
+	fp.Close()
+}
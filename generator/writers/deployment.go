@@ -10,35 +10,106 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-// BuildDeployment builds a deployment.
+// BuildDeployment builds a deployment, a statefulset or a daemonset.
 func BuildDeployment(deployment *helm.Deployment, name, templatesDir string) {
-	kind := "deployment"
+	kind := strings.ToLower(deployment.Kind)
 	fname := filepath.Join(templatesDir, name+"."+kind+".yaml")
 	fp, _ := os.Create(fname)
 	buffer := bytes.NewBuffer(nil)
 	enc := yaml.NewEncoder(buffer)
 	enc.SetIndent(IndentSize)
 	enc.Encode(deployment)
-	_content := string(buffer.Bytes())
-	content := strings.Split(string(_content), "\n")
-	dataname := ""
 	component := deployment.Spec.Selector["matchLabels"].(map[string]string)[helm.K+"/component"]
+	fp.WriteString(enabledCondition(name))
+
+	// in a statefulset, the pod volume of a claim template is an emptyDir used when the persistence is disabled
+	claims := make(map[string]bool)
+	for _, claim := range deployment.Spec.VolumeClaimTemplates {
+		claims[claim.Metadata.Name] = true
+	}
+	writePodLines(fp, strings.Split(buffer.String(), "\n"), component, claims, &deployment.Spec.Template.Spec)
+	writeVolumeClaimTemplates(fp, deployment, component)
+	fp.WriteString("{{- end }}")
+	fp.Close()
+
+}
+
+// writePodLines writes the lines of an object that contains a pod template. The persistentVolumeClaim volumes
+// are only used if the persistence is enabled in values, else it's an "emptyDir". The persistence of a volume
+// shared with other deployments is the one of its owner. The emptyDir volumes named as a claim template are used
+// only if the persistence is disabled. The pod affinity to other deployments is only set if they are enabled.
+func writePodLines(fp *os.File, content []string, component string, claims map[string]bool, spec *helm.PodSpec) {
+	dataname := ""
 	n := 0 // will be count of lines only on "persistentVolumeClaim" line, to indent "else" and "end" at the right place
-	for _, line := range content {
+	endClaimAt := -1
+	affinityIndent := -1
+	for i, line := range content {
+		if affinityIndent > -1 && (line == "" || CountSpaces(line) <= affinityIndent) {
+			fp.WriteString(strings.Repeat(" ", affinityIndent) + "{{- end }}\n")
+			affinityIndent = -1
+		}
+		if strings.TrimSpace(line) == "affinity:" && len(spec.AffinityOwners) > 0 {
+			affinityIndent = CountSpaces(line)
+			condition := "and"
+			for _, owner := range spec.AffinityOwners {
+				condition += " .Values." + owner + ".enabled"
+			}
+			fp.WriteString(strings.Repeat(" ", affinityIndent) + "{{- if " + condition + " }}\n")
+		}
+		line = storageClassTemplate(toYamlTemplate(unquoteIntTemplate(line)))
+		if strings.HasPrefix(strings.TrimSpace(line), "replicas: {{") {
+			// the autoscaler manages the replicas when it's enabled
+			spaces := strings.Repeat(" ", CountSpaces(line))
+			line = spaces + "{{- if not .Values." + component + ".autoscaling.enabled }}\n" + line + "\n" + spaces + "{{- end }}"
+		}
+		if strings.TrimSpace(line) == "- emptyDir: {}" && i+1 < len(content) {
+			next := strings.TrimSpace(content[i+1])
+			if claimname := strings.TrimPrefix(next, "name: "); claimname != next && claims[claimname] {
+				line = strings.Repeat(" ", CountSpaces(line)) +
+					"{{- if not .Values." + component + ".persistence." + claimname + ".enabled }}\n" + line
+				endClaimAt = i + 1
+			}
+		}
 		if strings.Contains(line, "name:") {
 			dataname = strings.Split(line, ":")[1]
 			dataname = strings.TrimSpace(dataname)
 		} else if strings.Contains(line, "persistentVolumeClaim") {
 			n = CountSpaces(line)
-			line = strings.Repeat(" ", n) + "{{- if  .Values." + component + ".persistence." + dataname + ".enabled }}\n" + line
+			owner := component
+			if o, ok := spec.VolumeOwners[dataname]; ok {
+				owner = o
+			}
+			line = strings.Repeat(" ", n) + "{{- if  .Values." + owner + ".persistence." + dataname + ".enabled }}\n" + line
 		} else if strings.Contains(line, "claimName") {
 			spaces := strings.Repeat(" ", n)
 			line += "\n" + spaces + "{{ else }}"
 			line += "\n" + spaces + "emptyDir: {}"
 			line += "\n" + spaces + "{{- end }}"
 		}
+		if i == endClaimAt {
+			line += "\n" + strings.Repeat(" ", CountSpaces(content[i-1])) + "{{- end }}"
+		}
 		fp.WriteString(line + "\n")
 	}
-	fp.Close()
+}
 
+// writeVolumeClaimTemplates appends the claim templates of a statefulset to the spec, each one is only
+// set if the persistence of the volume is enabled.
+func writeVolumeClaimTemplates(fp *os.File, deployment *helm.Deployment, component string) {
+	if len(deployment.Spec.VolumeClaimTemplates) == 0 {
+		return
+	}
+	indent := strings.Repeat(" ", IndentSize)
+	fp.WriteString(indent + "volumeClaimTemplates:\n")
+	for _, claim := range deployment.Spec.VolumeClaimTemplates {
+		buffer := bytes.NewBuffer(nil)
+		enc := yaml.NewEncoder(buffer)
+		enc.SetIndent(IndentSize)
+		enc.Encode([]*helm.VolumeClaimTemplate{claim})
+		fp.WriteString(indent + "{{- if .Values." + component + ".persistence." + claim.Metadata.Name + ".enabled }}\n")
+		for _, line := range strings.Split(strings.TrimRight(buffer.String(), "\n"), "\n") {
+			fp.WriteString(storageClassTemplate(toYamlTemplate(indent+line)) + "\n")
+		}
+		fp.WriteString(indent + "{{- end }}\n")
+	}
 }
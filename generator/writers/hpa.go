@@ -0,0 +1,45 @@
+package writers
+
+import (
+	"bytes"
+	"katenary/helm"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BuildHPA writes the horizontalPodAutoscaler, it's only created if the autoscaling is enabled in values.
+func BuildHPA(hpa *helm.HorizontalPodAutoscaler, name, templatesDir string) {
+	kind := "hpa"
+	fname := filepath.Join(templatesDir, name+"."+kind+".yaml")
+	fp, _ := os.Create(fname)
+	fp.WriteString("{{- if and .Values." + name + ".enabled .Values." + name + ".autoscaling.enabled }}\n")
+	buffer := bytes.NewBuffer(nil)
+	enc := yaml.NewEncoder(buffer)
+	enc.SetIndent(IndentSize)
+	enc.Encode(hpa)
+	for _, line := range strings.Split(buffer.String(), "\n") {
+		if line != "" {
+			fp.WriteString(unquoteIntTemplate(line) + "\n")
+		}
+	}
+
+	// each metric is set only if its target is set
+	indent := strings.Repeat(" ", IndentSize)
+	fp.WriteString(indent + "metrics:\n")
+	for _, metric := range hpa.Spec.Metrics {
+		buffer := bytes.NewBuffer(nil)
+		enc := yaml.NewEncoder(buffer)
+		enc.SetIndent(IndentSize)
+		enc.Encode([]*helm.HPAMetric{metric})
+		fp.WriteString(indent + "{{- if " + metric.Target + " }}\n")
+		for _, line := range strings.Split(strings.TrimRight(buffer.String(), "\n"), "\n") {
+			fp.WriteString(indent + unquoteIntTemplate(line) + "\n")
+		}
+		fp.WriteString(indent + "{{- end }}\n")
+	}
+	fp.WriteString("{{- end }}")
+	fp.Close()
+}
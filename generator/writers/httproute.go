@@ -0,0 +1,74 @@
+package writers
+
+import (
+	"bytes"
+	"katenary/helm"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// optionalTemplateRE matches a field of the parent Gateway that is only set if the value is not empty.
+var optionalTemplateRE = regexp.MustCompile(`^(\s*)(namespace|sectionName): '(\{\{ .* \}\})'$`)
+
+// BuildHTTPRoute writes the Gateway API route, it's enabled with the ingress of the service in values. The
+// fname is the name of the file, without extension, as an ingress can give several routes.
+func BuildHTTPRoute(route *helm.HTTPRoute, name, fname, templatesDir string) {
+	kind := "httproute"
+	fp, err := os.Create(filepath.Join(templatesDir, fname+"."+kind+".yaml"))
+	if err != nil {
+		panic(err)
+	}
+	defer fp.Close()
+
+	buffer := bytes.NewBuffer(nil)
+	enc := yaml.NewEncoder(buffer)
+	enc.SetIndent(IndentSize)
+	enc.Encode(route)
+
+	// the rules of a shared ingress are only kept if their service is enabled
+	conditions := make([]string, 0)
+	for _, r := range route.Spec.Rules {
+		conditions = append(conditions, r.Service)
+	}
+	ruleCondition := ""
+	ruleIndent := 0
+	inRules := false
+
+	fp.WriteString("{{- if and .Values." + name + ".enabled .Values." + name + ".ingress.enabled }}\n")
+	for _, l := range strings.Split(buffer.String(), "\n") {
+		if l == "" {
+			continue
+		}
+		n := CountSpaces(l)
+		switch {
+		case strings.HasPrefix(l, strings.Repeat(" ", n)+"annotations:"):
+			inner := strings.Repeat(" ", n+IndentSize)
+			l += "\n" + inner + "{{- range $k, $v := .Values." + name + ".ingress.annotations }}\n" +
+				inner + "{{ $k }}: {{ $v }}\n" +
+				inner + "{{- end }}"
+		case optionalTemplateRE.MatchString(l):
+			m := optionalTemplateRE.FindStringSubmatch(l)
+			tpl := strings.TrimSuffix(strings.TrimPrefix(m[3], "{{ "), " }}")
+			l = m[1] + "{{- with " + tpl + " }}\n" +
+				m[1] + m[2] + ": {{ . }}\n" +
+				m[1] + "{{- end }}"
+		case strings.HasPrefix(strings.TrimSpace(l), "rules:"):
+			inRules = true
+		case inRules && strings.HasPrefix(strings.TrimSpace(l), "- matches:") && len(conditions) > 0:
+			ruleCondition, conditions = conditions[0], conditions[1:]
+			ruleIndent = n
+			if ruleCondition != "" {
+				l = strings.Repeat(" ", n) + "{{- if .Values." + ruleCondition + ".enabled }}\n" + l
+			}
+		case inRules && strings.HasPrefix(strings.TrimSpace(l), "port:") && ruleCondition != "":
+			// the port of the backend is the last line of a rule
+			l += "\n" + strings.Repeat(" ", ruleIndent) + "{{- end }}"
+		}
+		fp.WriteString(unquoteIntTemplate(l) + "\n")
+	}
+	fp.WriteString("{{- end }}")
+}
@@ -5,6 +5,7 @@ import (
 	"katenary/helm"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -39,8 +40,9 @@ func BuildIngress(ingress *helm.Ingress, name, templatesDir string) {
 	fname := filepath.Join(templatesDir, name+"."+kind+".yaml")
 	enc := yaml.NewEncoder(buffer)
 	enc.SetIndent(IndentSize)
-	buffer.WriteString("{{- if .Values." + name + ".ingress.enabled -}}\n")
+	buffer.WriteString("{{- if and .Values." + name + ".enabled .Values." + name + ".ingress.enabled -}}\n")
 	enc.Encode(ingress)
+	writeIngressTLS(buffer, ingress, name)
 	buffer.WriteString("{{- end -}}")
 
 	fp, err := os.Create(fname)
@@ -52,7 +54,16 @@ func BuildIngress(ingress *helm.Ingress, name, templatesDir string) {
 	content := string(buffer.Bytes())
 	lines := strings.Split(content, "\n")
 
-	backendHit := false
+	// the paths of a shared ingress are only kept if their service is enabled
+	conditions := make([]string, 0)
+	for _, r := range ingress.Spec.Rules {
+		for _, p := range r.Http.Paths {
+			conditions = append(conditions, p.Service)
+		}
+	}
+	pathCondition := ""
+	pathIndent := 0
+
 	for _, l := range lines {
 		// apiVersion is a pain...
 		if strings.Contains(l, "apiVersion:") {
@@ -64,6 +75,13 @@ func BuildIngress(ingress *helm.Ingress, name, templatesDir string) {
 			n := CountSpaces(l) + IndentSize
 			l += "\n" + strings.Repeat(" ", n) + "{{- range $k, $v := .Values.__name__.ingress.annotations }}\n"
 			l += strings.Repeat(" ", n) + "{{ $k }}: {{ $v }}\n"
+			l += strings.Repeat(" ", n) + "{{- end }}\n"
+			l += strings.Repeat(" ", n) + "{{- with .Values.__name__.ingress.certManager.issuer }}\n"
+			l += strings.Repeat(" ", n) + "{{- if eq $.Values.__name__.ingress.certManager.issuerKind \"Issuer\" }}\n"
+			l += strings.Repeat(" ", n) + "cert-manager.io/issuer: {{ . }}\n"
+			l += strings.Repeat(" ", n) + "{{- else }}\n"
+			l += strings.Repeat(" ", n) + "cert-manager.io/cluster-issuer: {{ . }}\n"
+			l += strings.Repeat(" ", n) + "{{- end }}\n"
 			l += strings.Repeat(" ", n) + "{{- end }}"
 			l = strings.ReplaceAll(l, "__name__", name)
 		}
@@ -87,15 +105,52 @@ func BuildIngress(ingress *helm.Ingress, name, templatesDir string) {
 			n := CountSpaces(l)
 			l = strings.Repeat(" ", n) + versionCondition119 + l
 		}
-		if strings.Contains(l, "serviceName:") || strings.Contains(l, "servicePort:") {
+		// each backend has got a serviceName and then a servicePort field
+		if strings.Contains(l, "serviceName:") {
 			n := CountSpaces(l)
-			if !backendHit {
-				l = strings.Repeat(" ", n) + "{{- else }}\n" + l
-			} else {
-				l = l + "\n" + strings.Repeat(" ", n) + "{{- end }}\n"
+			l = strings.Repeat(" ", n) + "{{- else }}\n" + l
+		}
+		if strings.Contains(l, "servicePort:") {
+			n := CountSpaces(l)
+			l = l + "\n" + strings.Repeat(" ", n) + "{{- end }}"
+			if pathCondition != "" {
+				l += "\n" + strings.Repeat(" ", pathIndent) + "{{- end }}"
+			}
+		}
+		if strings.HasPrefix(strings.TrimSpace(l), "- path:") && len(conditions) > 0 {
+			pathCondition, conditions = conditions[0], conditions[1:]
+			pathIndent = CountSpaces(l)
+			if pathCondition != "" {
+				l = strings.Repeat(" ", pathIndent) + "{{- if .Values." + pathCondition + ".enabled }}\n" + l
 			}
-			backendHit = true
 		}
 		fp.WriteString(l + "\n")
 	}
 }
+
+// writeIngressTLS writes the tls section of the ingress spec if it's enabled in values. The hosts are the ones
+// of the rules, unless they are set in values.
+func writeIngressTLS(buffer *bytes.Buffer, ingress *helm.Ingress, name string) {
+	if len(ingress.Spec.TLS) == 0 {
+		return
+	}
+	indent := strings.Repeat(" ", IndentSize)
+	item := strings.Repeat(" ", IndentSize*2)
+	hosts := item + strings.Repeat(" ", IndentSize)
+	hostItem := hosts + indent
+
+	buffer.WriteString(indent + "{{- if .Values." + name + ".ingress.tls.enabled }}\n")
+	buffer.WriteString(indent + "tls:\n")
+	for _, tls := range ingress.Spec.TLS {
+		buffer.WriteString(item + "- secretName: " + tls.SecretName + "\n")
+		buffer.WriteString(hosts + "hosts:\n")
+		buffer.WriteString(hostItem + "{{- with .Values." + name + ".ingress.tls.hosts }}\n")
+		buffer.WriteString(hostItem + "{{- toYaml . | nindent " + strconv.Itoa(len(hostItem)) + " }}\n")
+		buffer.WriteString(hostItem + "{{- else }}\n")
+		for _, host := range tls.Hosts {
+			buffer.WriteString(hostItem + "- " + host + "\n")
+		}
+		buffer.WriteString(hostItem + "{{- end }}\n")
+	}
+	buffer.WriteString(indent + "{{- end }}\n")
+}
@@ -0,0 +1,66 @@
+package writers
+
+import (
+	"bytes"
+	"katenary/helm"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BuildRoute writes the OpenShift route, it's enabled with the ingress of the service in values. The fname is
+// the name of the file, without extension, as an ingress can give several routes.
+func BuildRoute(route *helm.Route, name, fname, templatesDir string) {
+	kind := "route"
+	fp, err := os.Create(filepath.Join(templatesDir, fname+"."+kind+".yaml"))
+	if err != nil {
+		panic(err)
+	}
+	defer fp.Close()
+
+	buffer := bytes.NewBuffer(nil)
+	enc := yaml.NewEncoder(buffer)
+	enc.SetIndent(IndentSize)
+	enc.Encode(route)
+
+	condition := "and .Values." + name + ".enabled .Values." + name + ".ingress.enabled"
+	if route.Service != "" {
+		condition += " .Values." + route.Service + ".enabled"
+	}
+	termination := route.Spec.TLS.Termination
+	termination = strings.TrimSuffix(strings.TrimPrefix(termination, "{{ "), " }}")
+
+	fp.WriteString("{{- if " + condition + " }}\n")
+	for _, l := range strings.Split(buffer.String(), "\n") {
+		if l == "" {
+			continue
+		}
+		n := CountSpaces(l)
+		indent := strings.Repeat(" ", n)
+		switch {
+		case strings.HasPrefix(l, indent+"annotations:"):
+			inner := strings.Repeat(" ", n+IndentSize)
+			l += "\n" + inner + "{{- range $k, $v := .Values." + name + ".ingress.annotations }}\n" +
+				inner + "{{ $k }}: {{ $v }}\n" +
+				inner + "{{- end }}"
+		case strings.HasPrefix(l, indent+"path:"):
+			// a passthrough route can't have a path
+			l = indent + "{{- if ne " + termination + " \"passthrough\" }}\n" + l + "\n" + indent + "{{- end }}"
+		}
+		fp.WriteString(unquoteIntTemplate(l) + "\n")
+	}
+
+	// the TLS termination is only set if it's given in values
+	indent := strings.Repeat(" ", IndentSize)
+	inner := strings.Repeat(" ", IndentSize*2)
+	fp.WriteString(indent + "{{- with " + termination + " }}\n")
+	fp.WriteString(indent + "tls:\n")
+	fp.WriteString(inner + "termination: {{ . }}\n")
+	fp.WriteString(inner + "{{- if ne . \"passthrough\" }}\n")
+	fp.WriteString(inner + "insecureEdgeTerminationPolicy: " + strings.Replace(route.Spec.TLS.InsecureEdgeTerminationPolicy, ".Values", "$.Values", 1) + "\n")
+	fp.WriteString(inner + "{{- end }}\n")
+	fp.WriteString(indent + "{{- end }}\n")
+	fp.WriteString("{{- end }}")
+}
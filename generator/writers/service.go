@@ -1,24 +1,77 @@
 package writers
 
 import (
+	"bytes"
 	"katenary/helm"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
-// BuildService writes the service (external or not).
+// nodePortRE matches a node port set to a template of values.
+var nodePortRE = regexp.MustCompile(`^(\s*)nodePort: '\{\{ (.*) \}\}'$`)
+
+// BuildService writes the service, or the headless service of a StatefulSet. The type, the annotations and
+// the node ports of the service are taken from values.
 func BuildService(service *helm.Service, name, templatesDir string) {
 	kind := "service"
 	suffix := ""
-	if service.Spec.Type == "NodePort" {
-		suffix = "-external"
+	if service.Spec.ClusterIP == "None" {
+		suffix = "-headless"
 	}
 	fname := filepath.Join(templatesDir, name+suffix+"."+kind+".yaml")
 	fp, _ := os.Create(fname)
-	enc := yaml.NewEncoder(fp)
+	fp.WriteString(enabledCondition(name))
+	buffer := bytes.NewBuffer(nil)
+	enc := yaml.NewEncoder(buffer)
 	enc.SetIndent(IndentSize)
 	enc.Encode(service)
+	fromValues := strings.HasPrefix(service.Spec.Type, "{{")
+	for _, line := range strings.Split(buffer.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		if fromValues {
+			line = serviceValuesLine(line, name)
+		}
+		fp.WriteString(unquoteIntTemplate(line) + "\n")
+	}
+	fp.WriteString("{{- end }}")
 	fp.Close()
 }
+
+// serviceValuesLine adds the options of .Values.<name>.service to a line of the service.
+func serviceValuesLine(line, name string) string {
+	values := ".Values." + name + ".service"
+	n := CountSpaces(line)
+	indent := strings.Repeat(" ", n)
+	switch {
+	case strings.HasPrefix(line, indent+"annotations:"):
+		inner := indent + strings.Repeat(" ", IndentSize)
+		line += "\n" + inner + "{{- range $k, $v := " + values + ".annotations }}\n" +
+			inner + "{{ $k }}: {{ $v | quote }}\n" +
+			inner + "{{- end }}"
+	case strings.HasPrefix(line, indent+"type: "):
+		line = indent + "{{- if " + values + ".headless }}\n" +
+			indent + "clusterIP: None\n" +
+			indent + "{{- else }}\n" +
+			indent + "type: {{ " + values + ".type }}\n" +
+			indent + "{{- with " + values + ".loadBalancerIP }}\n" +
+			indent + "loadBalancerIP: {{ . }}\n" +
+			indent + "{{- end }}\n" +
+			indent + "{{- if and " + values + ".externalTrafficPolicy (ne " + values + ".type \"ClusterIP\") }}\n" +
+			indent + "externalTrafficPolicy: {{ " + values + ".externalTrafficPolicy }}\n" +
+			indent + "{{- end }}\n" +
+			indent + "{{- end }}"
+	case nodePortRE.MatchString(line):
+		// node ports are only allowed for NodePort and LoadBalancer services
+		tpl := nodePortRE.FindStringSubmatch(line)[2]
+		line = indent + "{{- if and (not " + values + ".headless) (ne " + values + ".type \"ClusterIP\") (" + tpl + ") }}\n" +
+			indent + "nodePort: {{ " + tpl + " }}\n" +
+			indent + "{{- end }}"
+	}
+	return line
+}
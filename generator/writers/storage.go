@@ -1,15 +1,17 @@
 package writers
 
 import (
+	"bytes"
 	"katenary/helm"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
-// BuildStorage writes the persistentVolumeClaim.
+// BuildStorage writes the persistentVolumeClaim. It's not created if an existing claim is given in values.
 func BuildStorage(storage *helm.Storage, name, templatesDir string) {
 	kind := "pvc"
 	name = storage.Metadata.Labels[helm.K+"/component"]
@@ -21,12 +23,36 @@ func BuildStorage(storage *helm.Storage, name, templatesDir string) {
 	}
 	defer fp.Close()
 	volname := storage.K8sBase.Metadata.Labels[helm.K+"/pvc-name"]
+	values := ".Values." + name + ".persistence." + volname
 
-	fp.WriteString("{{ if .Values." + name + ".persistence." + volname + ".enabled }}\n")
-	enc := yaml.NewEncoder(fp)
+	enabled := ".Values." + name + ".enabled"
+	if len(storage.Deployments) > 1 {
+		// a shared claim is used by the other deployments even if its owner is disabled
+		enabled = "(or"
+		for _, deployment := range storage.Deployments {
+			enabled += " .Values." + deployment + ".enabled"
+		}
+		enabled += ")"
+	}
+	fp.WriteString("{{ if and " + enabled + " " + values + ".enabled (not " + values + ".existingClaim) }}\n")
+	buffer := bytes.NewBuffer(nil)
+	enc := yaml.NewEncoder(buffer)
 	enc.SetIndent(IndentSize)
 	if err := enc.Encode(storage); err != nil {
 		log.Fatal(err)
 	}
+	for _, line := range strings.Split(buffer.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, strings.Repeat(" ", IndentSize)+"annotations:") {
+			// add the annotations from values, e.g. to keep the claim when the release is deleted
+			inner := strings.Repeat(" ", IndentSize*2)
+			line += "\n" + inner + "{{- range $k, $v := " + values + ".annotations }}\n" +
+				inner + "{{ $k }}: {{ $v | quote }}\n" +
+				inner + "{{- end }}"
+		}
+		fp.WriteString(storageClassTemplate(toYamlTemplate(line)) + "\n")
+	}
 	fp.WriteString("{{- end -}}")
 }
@@ -1,5 +1,11 @@
 package writers
 
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
 // IndentSize set the indentation size for yaml output. Could ba changed by command line argument.
 var IndentSize = 2
 
@@ -15,3 +21,48 @@ func CountSpaces(line string) int {
 	}
 	return spaces
 }
+
+// enabledCondition returns the condition to activate objects of the service only if it's enabled in values.
+func enabledCondition(name string) string {
+	return "{{- if .Values." + name + ".enabled }}\n"
+}
+
+// toYamlTemplateRE matches a field set to a "toYaml" template of values.
+var toYamlTemplateRE = regexp.MustCompile(`^(\s*)(- )?([\w.-]+): '\{\{ toYaml (\.Values\.[\w.-]+) \}\}'$`)
+
+// toYamlTemplate changes a field set to "{{ toYaml .Values.x }}" to a block rendered from values, with the
+// right indentation.
+func toYamlTemplate(line string) string {
+	m := toYamlTemplateRE.FindStringSubmatch(line)
+	if m == nil {
+		return line
+	}
+	indent := len(m[1]) + len(m[2]) + IndentSize
+	return fmt.Sprintf("%s%s%s:\n%s{{- toYaml %s | nindent %d }}", m[1], m[2], m[3], strings.Repeat(" ", indent), m[4], indent)
+}
+
+// intTemplateRE matches a port, or another integer or boolean field, set to a quoted template.
+var intTemplateRE = regexp.MustCompile(`^(\s*(?:- )?(?:containerPort|port|targetPort|number|servicePort|` +
+	`suspend|successfulJobsHistoryLimit|failedJobsHistoryLimit|replicas|minReplicas|maxReplicas|averageUtilization|` +
+	`periodSeconds|initialDelaySeconds|timeoutSeconds|successThreshold|failureThreshold): )'(\{\{.*\}\})'$`)
+
+// unquoteIntTemplate removes the quotes around the templates of port values, and other integer or boolean
+// fields, as they must be rendered as integers or booleans.
+func unquoteIntTemplate(line string) string {
+	return intTemplateRE.ReplaceAllString(line, "$1$2")
+}
+
+// storageClassRE matches a storage class set to a template of values.
+var storageClassRE = regexp.MustCompile(`^(\s*)storageClassName: '\{\{ (\.Values\.[\w.-]+) \}\}'$`)
+
+// storageClassTemplate changes a storage class set from values to be only set if the value is not empty. The "-"
+// value gives an empty storage class, to disable the dynamic provisioning.
+func storageClassTemplate(line string) string {
+	m := storageClassRE.FindStringSubmatch(line)
+	if m == nil {
+		return line
+	}
+	return m[1] + "{{- with " + m[2] + " }}\n" +
+		m[1] + `storageClassName: {{ if eq . "-" }}""{{ else }}{{ . }}{{ end }}` + "\n" +
+		m[1] + "{{- end }}"
+}
@@ -1,23 +1,37 @@
 package helm
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"strings"
+	"unicode/utf8"
 )
 
 // InlineConfig is made to represent a configMap or a secret
 type InlineConfig interface {
 	AddEnvFile(filename string) error
 	AddEnv(key, val string) error
+	AddFile(key string, content []byte) error
+	AddChartFile(file *ChartFile)
 	Metadata() *Metadata
 }
 
-// ConfigMap is made to represent a configMap with data.
+// ChartFile is a file that is copied in the chart, the configMap or the secret loads it with .Files.Get.
+type ChartFile struct {
+	Key    string // the key in the configMap or the secret
+	Path   string // the path in the chart
+	Source string // the path of the file to copy
+	Binary bool   // the content is not valid UTF-8
+}
+
+// ConfigMap is made to represent a configMap with data. The binary files are base64 encoded in binaryData.
 type ConfigMap struct {
-	*K8sBase `yaml:",inline"`
-	Data     map[string]string `yaml:"data"`
+	*K8sBase   `yaml:",inline"`
+	Data       map[string]string `yaml:"data"`
+	BinaryData map[string]string `yaml:"binaryData,omitempty"`
+	Files      []*ChartFile      `yaml:"-"`
 }
 
 // NewConfigMap returns a new initialzed ConfigMap.
@@ -68,10 +82,29 @@ func (c *ConfigMap) AddEnv(key, val string) error {
 	return nil
 }
 
+// AddFile adds a file content to the configMap. The content that is not valid UTF-8 is added in binaryData.
+func (c *ConfigMap) AddFile(key string, content []byte) error {
+	if !utf8.Valid(content) {
+		if c.BinaryData == nil {
+			c.BinaryData = make(map[string]string)
+		}
+		c.BinaryData[key] = base64.StdEncoding.EncodeToString(content)
+		return nil
+	}
+	c.Data[key] = string(content)
+	return nil
+}
+
+// AddChartFile adds a file that is copied in the chart to the configMap.
+func (c *ConfigMap) AddChartFile(file *ChartFile) {
+	c.Files = append(c.Files, file)
+}
+
 // Secret is made to represent a secret with data.
 type Secret struct {
 	*K8sBase `yaml:",inline"`
 	Data     map[string]string `yaml:"data"`
+	Files    []*ChartFile      `yaml:"-"`
 }
 
 // NewSecret returns a new initialzed Secret.
@@ -124,3 +157,14 @@ func (s *Secret) AddEnv(key, val string) error {
 	s.Data[key] = fmt.Sprintf(`{{ %s | b64enc }}`, val)
 	return nil
 }
+
+// AddFile adds a file content to the secret, base64 encoded.
+func (s *Secret) AddFile(key string, content []byte) error {
+	s.Data[key] = base64.StdEncoding.EncodeToString(content)
+	return nil
+}
+
+// AddChartFile adds a file that is copied in the chart to the secret.
+func (s *Secret) AddChartFile(file *ChartFile) {
+	s.Files = append(s.Files, file)
+}
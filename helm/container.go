@@ -9,10 +9,11 @@ import (
 
 type EnvValue interface{}
 
-// ContainerPort represent a port mapping.
+// ContainerPort represent a port mapping. The port is an int or a template that renders an int.
 type ContainerPort struct {
 	Name          string
-	ContainerPort int `yaml:"containerPort"`
+	ContainerPort interface{} `yaml:"containerPort"`
+	Protocol      string      `yaml:"protocol,omitempty"`
 }
 
 // Value represent a environment variable with name and value.
@@ -23,14 +24,17 @@ type Value struct {
 
 // Container represent a container with name, image, and environment variables. It is used in Deployment.
 type Container struct {
-	Name          string                         `yaml:"name,omitempty"`
-	Image         string                         `yaml:"image"`
-	Ports         []*ContainerPort               `yaml:"ports,omitempty"`
-	Env           []*Value                       `yaml:"env,omitempty"`
-	EnvFrom       []map[string]map[string]string `yaml:"envFrom,omitempty"`
-	Command       []string                       `yaml:"command,omitempty"`
-	VolumeMounts  []interface{}                  `yaml:"volumeMounts,omitempty"`
-	LivenessProbe *Probe                         `yaml:"livenessProbe,omitempty"`
+	Name           string                         `yaml:"name,omitempty"`
+	Image          string                         `yaml:"image"`
+	Ports          []*ContainerPort               `yaml:"ports,omitempty"`
+	Env            []*Value                       `yaml:"env,omitempty"`
+	EnvFrom        []map[string]map[string]string `yaml:"envFrom,omitempty"`
+	Command        []string                       `yaml:"command,omitempty"`
+	VolumeMounts   []interface{}                  `yaml:"volumeMounts,omitempty"`
+	LivenessProbe  *Probe                         `yaml:"livenessProbe,omitempty"`
+	ReadinessProbe *Probe                         `yaml:"readinessProbe,omitempty"`
+	StartupProbe   *Probe                         `yaml:"startupProbe,omitempty"`
+	Resources      interface{}                    `yaml:"resources,omitempty"`
 }
 
 // NewContainer creates a new container with name, image, labels and environment variables.
@@ -0,0 +1,50 @@
+package helm
+
+// CronJob is a k8s CronJob.
+type CronJob struct {
+	*K8sBase `yaml:",inline"`
+	Spec     *CronJobSpec `yaml:"spec"`
+}
+
+// CronJobSpec is the spec of a CronJob, the schedule and the policies are templates of values.
+type CronJobSpec struct {
+	Schedule                   string      `yaml:"schedule"`
+	ConcurrencyPolicy          string      `yaml:"concurrencyPolicy"`
+	Suspend                    string      `yaml:"suspend"`
+	SuccessfulJobsHistoryLimit string      `yaml:"successfulJobsHistoryLimit"`
+	FailedJobsHistoryLimit     string      `yaml:"failedJobsHistoryLimit"`
+	JobTemplate                JobTemplate `yaml:"jobTemplate"`
+}
+
+// JobTemplate is the template of the jobs created by a CronJob.
+type JobTemplate struct {
+	Spec JobSpec `yaml:"spec"`
+}
+
+// JobSpec is the spec of a Job.
+type JobSpec struct {
+	Template PodTemplate `yaml:"template"`
+}
+
+// NewCronJob creates a CronJob that runs the pod of the given deployment. The schedule and the policies are
+// set in the "cronjob" section of the service values.
+func NewCronJob(name string, deployment *Deployment) *CronJob {
+	c := &CronJob{K8sBase: NewBase()}
+	c.K8sBase.Metadata.Name = ReleaseNameTpl + "-" + name
+	c.K8sBase.ApiVersion = "batch/v1"
+	c.K8sBase.Kind = "CronJob"
+	c.K8sBase.Metadata.Labels[K+"/component"] = name
+
+	values := ".Values." + name + ".cronjob."
+	c.Spec = &CronJobSpec{
+		Schedule:                   "{{ " + values + "schedule }}",
+		ConcurrencyPolicy:          "{{ " + values + "concurrencyPolicy }}",
+		Suspend:                    "{{ " + values + "suspend }}",
+		SuccessfulJobsHistoryLimit: "{{ " + values + "successfulJobsHistoryLimit }}",
+		FailedJobsHistoryLimit:     "{{ " + values + "failedJobsHistoryLimit }}",
+	}
+	// the pods of a job must not be restarted "Always"
+	c.Spec.JobTemplate.Spec.Template = deployment.Spec.Template
+	c.Spec.JobTemplate.Spec.Template.Spec.RestartPolicy = "OnFailure"
+	return c
+}
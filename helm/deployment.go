@@ -15,10 +15,40 @@ func NewDeployment(name string) *Deployment {
 	return d
 }
 
+// NewStatefulSet creates a deployment of "StatefulSet" kind. The pods are reachable with the
+// given headless service, and get their own volumes from the VolumeClaimTemplates.
+func NewStatefulSet(name, serviceName string) *Deployment {
+	d := NewDeployment(name)
+	d.K8sBase.Kind = "StatefulSet"
+	d.Spec.ServiceName = ReleaseNameTpl + "-" + serviceName
+	return d
+}
+
+// NewDaemonSet creates a deployment of "DaemonSet" kind, that runs a pod on each node. The tolerations
+// and the update strategy are set in values.
+func NewDaemonSet(name string) *Deployment {
+	d := NewDeployment(name)
+	d.K8sBase.Kind = "DaemonSet"
+	d.Spec.Replicas = nil
+	d.Spec.UpdateStrategy = "{{ toYaml .Values." + name + ".updateStrategy }}"
+	d.Spec.Template.Spec.Tolerations = "{{ toYaml .Values." + name + ".tolerations }}"
+	return d
+}
+
+// IsStatefulSet returns true if the deployment is a StatefulSet.
+func (d *Deployment) IsStatefulSet() bool {
+	return d.K8sBase.Kind == "StatefulSet"
+}
+
 type DepSpec struct {
-	Replicas int                    `yaml:"replicas"`
-	Selector map[string]interface{} `yaml:"selector"`
-	Template PodTemplate            `yaml:"template"`
+	Replicas       interface{}            `yaml:"replicas,omitempty"`
+	ServiceName    string                 `yaml:"serviceName,omitempty"`
+	UpdateStrategy interface{}            `yaml:"updateStrategy,omitempty"`
+	Selector       map[string]interface{} `yaml:"selector"`
+	Template       PodTemplate            `yaml:"template"`
+
+	// VolumeClaimTemplates are written by the deployment writer, as each one depends on values.
+	VolumeClaimTemplates []*VolumeClaimTemplate `yaml:"-"`
 }
 
 func NewDepSpec() *DepSpec {
@@ -28,9 +58,15 @@ func NewDepSpec() *DepSpec {
 }
 
 type PodSpec struct {
-	InitContainers []*Container             `yaml:"initContainers,omitempty"`
-	Containers     []*Container             `yaml:"containers"`
-	Volumes        []map[string]interface{} `yaml:"volumes,omitempty"`
+	InitContainers  []*Container             `yaml:"initContainers,omitempty"`
+	Containers      []*Container             `yaml:"containers"`
+	Volumes         []map[string]interface{} `yaml:"volumes,omitempty"`
+	SecurityContext map[string]interface{}   `yaml:"securityContext,omitempty"`
+	Tolerations     interface{}              `yaml:"tolerations,omitempty"`
+	Affinity        map[string]interface{}   `yaml:"affinity,omitempty"`
+	RestartPolicy   string                   `yaml:"restartPolicy,omitempty"`
+	VolumeOwners    map[string]string        `yaml:"-"` // the deployments that own the claims of shared volumes
+	AffinityOwners  []string                 `yaml:"-"` // the deployments of the pod affinity, it's set if they are enabled
 }
 
 type PodTemplate struct {
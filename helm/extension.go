@@ -0,0 +1,191 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EXTENSION is the compose extension name that can be used instead of labels.
+const EXTENSION = "x-katenary"
+
+// Extension is the typed form of the katenary labels. It can be set in a service with the "x-katenary"
+// extension, or at the top level of the compose file to set defaults for every service.
+type Extension struct {
+	Ignore           *bool                        `yaml:"ignore,omitempty"`
+	SecretVars       []string                     `yaml:"secret-vars,omitempty"`
+	SecretEnvFiles   []string                     `yaml:"secret-envfiles,omitempty"`
+	MapEnv           map[string]string            `yaml:"mapenv,omitempty"`
+	Ports            []int                        `yaml:"ports,omitempty"`
+	Ingress          IngressOption                `yaml:"ingress,omitempty"`
+	ConfigMapVolumes []string                     `yaml:"configmap-volumes,omitempty"`
+	LocalVolumes     map[string]string            `yaml:"local-volumes,omitempty"`
+	SamePod          string                       `yaml:"same-pod,omitempty"`
+	VolumeFrom       map[string]map[string]string `yaml:"volume-from,omitempty"`
+	EmptyDirs        []string                     `yaml:"empty-dirs,omitempty"`
+	HealthCheck      string                       `yaml:"healthcheck,omitempty"`
+	InferProbes      *bool                        `yaml:"infer-probes,omitempty"`
+	Readiness        string                       `yaml:"readiness,omitempty"`
+	Startup          string                       `yaml:"startup,omitempty"`
+	Workload         string                       `yaml:"workload,omitempty"`
+	CronJob          string                       `yaml:"cronjob,omitempty"`
+	SharedIngress    string                       `yaml:"shared-ingress,omitempty"`
+	VolumeSharing    string                       `yaml:"volume-sharing,omitempty"`
+}
+
+// IngressOption is the "ingress" option of the extension, a port or a list of rules.
+type IngressOption struct {
+	Port  int
+	Rules []*IngressRuleConfig
+}
+
+// UnmarshalYAML decodes a port or a list of rules.
+func (o *IngressOption) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&o.Port)
+	}
+	raw, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	return dec.Decode(&o.Rules)
+}
+
+// IsZero returns true if the option is not set.
+func (o IngressOption) IsZero() bool {
+	return o.Port == 0 && len(o.Rules) == 0
+}
+
+// NewExtension decodes the "x-katenary" extension content. Unknown options and bad types are errors.
+func NewExtension(content interface{}) (*Extension, error) {
+	raw, err := yaml.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	ext := &Extension{}
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	if err := dec.Decode(ext); err != nil {
+		return nil, fmt.Errorf("%s is not valid: %w", EXTENSION, err)
+	}
+	return ext, nil
+}
+
+// Validate checks the values of the extension. Options that are specific to a service are not allowed
+// at the top level.
+func (e *Extension) Validate(topLevel bool) error {
+	ports := []int{e.Ingress.Port}
+	ports = append(ports, e.Ports...)
+	for _, port := range ports {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("%s: %d is not a valid port", EXTENSION, port)
+		}
+	}
+	if len(e.Ingress.Rules) > 0 {
+		if err := validateIngressRules(e.Ingress.Rules); err != nil {
+			return fmt.Errorf("%s: %w", EXTENSION, err)
+		}
+	}
+	if !topLevel {
+		return nil
+	}
+	for option, set := range map[string]bool{
+		"ignore":         e.Ignore != nil,
+		"secret-vars":    len(e.SecretVars) > 0,
+		"mapenv":         len(e.MapEnv) > 0,
+		"ports":          len(e.Ports) > 0,
+		"ingress":        !e.Ingress.IsZero(),
+		"same-pod":       e.SamePod != "",
+		"volume-from":    len(e.VolumeFrom) > 0,
+		"healthcheck":    e.HealthCheck != "",
+		"readiness":      e.Readiness != "",
+		"startup":        e.Startup != "",
+		"workload":       e.Workload != "",
+		"cronjob":        e.CronJob != "",
+		"shared-ingress": e.SharedIngress != "",
+	} {
+		if set {
+			return fmt.Errorf("%s: the %s option can only be set in a service", EXTENSION, option)
+		}
+	}
+	return nil
+}
+
+// Labels returns the labels that correspond to the options that are set.
+func (e *Extension) Labels() map[string]string {
+	labels := make(map[string]string)
+	if e.Ignore != nil {
+		labels[LABEL_IGNORE] = strconv.FormatBool(*e.Ignore)
+	}
+	if len(e.SecretVars) > 0 {
+		labels[LABEL_SECRETVARS] = strings.Join(e.SecretVars, ",")
+	}
+	if len(e.SecretEnvFiles) > 0 {
+		labels[LABEL_ENV_SECRET] = strings.Join(e.SecretEnvFiles, ",")
+	}
+	if len(e.MapEnv) > 0 {
+		mapenv, _ := yaml.Marshal(e.MapEnv)
+		labels[LABEL_MAP_ENV] = string(mapenv)
+	}
+	if len(e.Ports) > 0 {
+		ports := make([]string, len(e.Ports))
+		for i, port := range e.Ports {
+			ports[i] = strconv.Itoa(port)
+		}
+		labels[LABEL_PORT] = strings.Join(ports, ",")
+	}
+	if e.Ingress.Port > 0 {
+		labels[LABEL_INGRESS] = strconv.Itoa(e.Ingress.Port)
+	}
+	if len(e.Ingress.Rules) > 0 {
+		rules, _ := yaml.Marshal(e.Ingress.Rules)
+		labels[LABEL_INGRESS] = string(rules)
+	}
+	if len(e.ConfigMapVolumes) > 0 {
+		labels[LABEL_VOL_CM] = strings.Join(e.ConfigMapVolumes, ",")
+	}
+	if e.SamePod != "" {
+		labels[LABEL_SAMEPOD] = e.SamePod
+	}
+	if len(e.VolumeFrom) > 0 {
+		volumeFrom, _ := yaml.Marshal(e.VolumeFrom)
+		labels[LABEL_VOLUMEFROM] = string(volumeFrom)
+	}
+	if len(e.EmptyDirs) > 0 {
+		labels[LABEL_EMPTYDIRS] = strings.Join(e.EmptyDirs, ",")
+	}
+	if e.HealthCheck != "" {
+		labels[LABEL_HEALTHCHECK] = e.HealthCheck
+	}
+	if e.InferProbes != nil {
+		labels[LABEL_INFER_PROBES] = strconv.FormatBool(*e.InferProbes)
+	}
+	if e.Readiness != "" {
+		labels[LABEL_READINESS] = e.Readiness
+	}
+	if e.Startup != "" {
+		labels[LABEL_STARTUP] = e.Startup
+	}
+	if e.Workload != "" {
+		labels[LABEL_WORKLOAD] = e.Workload
+	}
+	if e.CronJob != "" {
+		labels[LABEL_CRONJOB] = e.CronJob
+	}
+	if e.SharedIngress != "" {
+		labels[LABEL_SHARED_INGR] = e.SharedIngress
+	}
+	if len(e.LocalVolumes) > 0 {
+		localVolumes, _ := yaml.Marshal(e.LocalVolumes)
+		labels[LABEL_LOCAL_VOLS] = string(localVolumes)
+	}
+	if e.VolumeSharing != "" {
+		labels[LABEL_VOL_SHARING] = e.VolumeSharing
+	}
+	return labels
+}
@@ -0,0 +1,64 @@
+package helm
+
+// HorizontalPodAutoscaler scales a deployment following the CPU and memory usage of its pods.
+type HorizontalPodAutoscaler struct {
+	*K8sBase `yaml:",inline"`
+	Spec     *HPASpec `yaml:"spec"`
+}
+
+// HPASpec is the spec of a HorizontalPodAutoscaler.
+type HPASpec struct {
+	ScaleTargetRef map[string]string `yaml:"scaleTargetRef"`
+	MinReplicas    string            `yaml:"minReplicas"`
+	MaxReplicas    string            `yaml:"maxReplicas"`
+
+	// Metrics are written by the hpa writer, each one is only set if its target is set in values.
+	Metrics []*HPAMetric `yaml:"-"`
+}
+
+// HPAMetric is a resource metric of a HorizontalPodAutoscaler.
+type HPAMetric struct {
+	Type     string                 `yaml:"type"`
+	Resource map[string]interface{} `yaml:"resource"`
+
+	// Target is the values path of the average utilization
+	Target string `yaml:"-"`
+}
+
+// NewHPA creates a HorizontalPodAutoscaler for the given deployment. The replicas and the targets are set in the
+// "autoscaling" section of the service values.
+func NewHPA(name string, deployment *Deployment) *HorizontalPodAutoscaler {
+	h := &HorizontalPodAutoscaler{K8sBase: NewBase()}
+	h.K8sBase.Metadata.Name = ReleaseNameTpl + "-" + name
+	h.K8sBase.ApiVersion = "autoscaling/v2"
+	h.K8sBase.Kind = "HorizontalPodAutoscaler"
+	h.K8sBase.Metadata.Labels[K+"/component"] = name
+
+	values := ".Values." + name + ".autoscaling."
+	h.Spec = &HPASpec{
+		ScaleTargetRef: map[string]string{
+			"apiVersion": deployment.ApiVersion,
+			"kind":       deployment.Kind,
+			"name":       deployment.Metadata.Name,
+		},
+		MinReplicas: "{{ " + values + "minReplicas }}",
+		MaxReplicas: "{{ " + values + "maxReplicas }}",
+	}
+	for _, metric := range [][2]string{
+		{"cpu", values + "targetCPUUtilizationPercentage"},
+		{"memory", values + "targetMemoryUtilizationPercentage"},
+	} {
+		h.Spec.Metrics = append(h.Spec.Metrics, &HPAMetric{
+			Type: "Resource",
+			Resource: map[string]interface{}{
+				"name": metric[0],
+				"target": map[string]string{
+					"type":               "Utilization",
+					"averageUtilization": "{{ " + metric[1] + " }}",
+				},
+			},
+			Target: metric[1],
+		})
+	}
+	return h
+}
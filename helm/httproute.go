@@ -0,0 +1,102 @@
+package helm
+
+import "fmt"
+
+// HTTPRoute is a Gateway API route, it's an alternative to the Ingress.
+type HTTPRoute struct {
+	*K8sBase `yaml:",inline"`
+	Spec     *HTTPRouteSpec `yaml:"spec"`
+}
+
+// HTTPRouteSpec is the spec of an HTTPRoute.
+type HTTPRouteSpec struct {
+	ParentRefs []*ParentRef     `yaml:"parentRefs"`
+	Hostnames  []string         `yaml:"hostnames"`
+	Rules      []*HTTPRouteRule `yaml:"rules"`
+}
+
+// ParentRef is the Gateway that the route is attached to.
+type ParentRef struct {
+	Name        string `yaml:"name"`
+	Namespace   string `yaml:"namespace,omitempty"`
+	SectionName string `yaml:"sectionName,omitempty"`
+}
+
+// HTTPRouteRule sends the requests that match the path to the backend.
+type HTTPRouteRule struct {
+	Matches     []*HTTPRouteMatch `yaml:"matches"`
+	BackendRefs []*BackendRef     `yaml:"backendRefs"`
+	Service     string            `yaml:"-"` // the service of the rule in a shared ingress, the rule is kept if it's enabled
+}
+
+// HTTPRouteMatch is the match of a route rule.
+type HTTPRouteMatch struct {
+	Path *HTTPPathMatch `yaml:"path"`
+}
+
+// HTTPPathMatch matches the path of the requests.
+type HTTPPathMatch struct {
+	Type  string `yaml:"type"`
+	Value string `yaml:"value"`
+}
+
+// BackendRef is the service that gets the requests.
+type BackendRef struct {
+	Name string      `yaml:"name"`
+	Port interface{} `yaml:"port"`
+}
+
+// NewHTTPRoute creates a route for the service, attached to the Gateway given in values.
+func NewHTTPRoute(name string) *HTTPRoute {
+	r := &HTTPRoute{
+		K8sBase: NewBase(),
+		Spec: &HTTPRouteSpec{
+			ParentRefs: []*ParentRef{{
+				Name:        "{{ .Values." + name + ".ingress.gateway.name }}",
+				Namespace:   "{{ .Values." + name + ".ingress.gateway.namespace }}",
+				SectionName: "{{ .Values." + name + ".ingress.gateway.sectionName }}",
+			}},
+			Hostnames: make([]string, 0),
+			Rules:     make([]*HTTPRouteRule, 0),
+		},
+	}
+	r.K8sBase.Metadata.Name = ReleaseNameTpl + "-" + name
+	r.K8sBase.Kind = "HTTPRoute"
+	r.K8sBase.ApiVersion = "gateway.networking.k8s.io/v1"
+	r.K8sBase.Metadata.Labels[K+"/component"] = name
+	return r
+}
+
+// NewHTTPRoutesFromIngress creates the routes that do the same as the ingress. As the hostnames of a route apply to
+// all its rules, each rule of the ingress gives a route.
+func NewHTTPRoutesFromIngress(name string, ingress *Ingress) []*HTTPRoute {
+	routes := make([]*HTTPRoute, 0, len(ingress.Spec.Rules))
+	for i, rule := range ingress.Spec.Rules {
+		route := NewHTTPRoute(name)
+		if i > 0 {
+			route.Metadata.Name = fmt.Sprintf("%s-%d", route.Metadata.Name, i)
+		}
+		for k, v := range ingress.Metadata.Annotations {
+			route.Metadata.Annotations[k] = v
+		}
+		route.Spec.Hostnames = append(route.Spec.Hostnames, rule.Host)
+		for _, p := range rule.Http.Paths {
+			pathType := "PathPrefix"
+			if p.PathType == "Exact" {
+				pathType = "Exact"
+			}
+			route.Spec.Rules = append(route.Spec.Rules, &HTTPRouteRule{
+				Matches: []*HTTPRouteMatch{{
+					Path: &HTTPPathMatch{Type: pathType, Value: p.Path},
+				}},
+				BackendRefs: []*BackendRef{{
+					Name: p.Backend.Service.Name,
+					Port: p.Backend.Service.Port["number"],
+				}},
+				Service: p.Service,
+			})
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
@@ -1,5 +1,13 @@
 package helm
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
 // Ingress is the kubernetes ingress object.
 type Ingress struct {
 	*K8sBase `yaml:",inline"`
@@ -25,6 +33,29 @@ func (i *Ingress) SetIngressClass(name string) {
 type IngressSpec struct {
 	IngressClassName string `yaml:"ingressClassName,omitempty"`
 	Rules            []IngressRule
+	TLS              []*IngressTLS `yaml:"-"` // written with a condition on values
+}
+
+// IngressTLS is the TLS configuration of an ingress, the certificate is in the secret.
+type IngressTLS struct {
+	SecretName string   `yaml:"secretName"`
+	Hosts      []string `yaml:"hosts"`
+}
+
+// SetTLS sets the TLS configuration from values, with the hosts of the rules by default.
+func (i *Ingress) SetTLS(name string) {
+	hosts := make([]string, 0)
+	done := make(map[string]bool)
+	for _, r := range i.Spec.Rules {
+		if !done[r.Host] {
+			done[r.Host] = true
+			hosts = append(hosts, r.Host)
+		}
+	}
+	i.Spec.TLS = []*IngressTLS{{
+		SecretName: `{{ .Values.` + name + `.ingress.tls.secretName | default (printf "%s-` + name + `-tls" .Release.Name) }}`,
+		Hosts:      hosts,
+	}}
 }
 
 type IngressRule struct {
@@ -40,6 +71,8 @@ type IngressPath struct {
 	Path     string
 	PathType string `yaml:"pathType"`
 	Backend  *IngressBackend
+	Service  string `yaml:"-"` // the service of the path in a shared ingress, the path is kept if it's enabled
+	PortName string `yaml:"-"` // the name of the port in the service, if it's known
 }
 
 type IngressBackend struct {
@@ -52,3 +85,60 @@ type IngressService struct {
 	Name string                 `yaml:"name"`
 	Port map[string]interface{} `yaml:"port"`
 }
+
+// IngressRuleConfig is a rule of the ingress label, or of the "ingress" option of the extension, in its
+// YAML form. An empty host is replaced by a default one.
+type IngressRuleConfig struct {
+	Host  string               `yaml:"host,omitempty"`
+	Paths []*IngressPathConfig `yaml:"paths"`
+}
+
+// IngressPathConfig is a path of an ingress rule, the port is the container port to reach.
+type IngressPathConfig struct {
+	Path     string `yaml:"path,omitempty"`
+	PathType string `yaml:"pathType,omitempty"`
+	Port     int    `yaml:"port"`
+}
+
+// ParseIngressLabel reads the ingress label. It's a port, that gives one rule on the "/" path, or a YAML list
+// of rules. The simple return value is true for the port form.
+func ParseIngressLabel(value string) (rules []*IngressRuleConfig, simple bool, err error) {
+	if port, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		rules = []*IngressRuleConfig{{Paths: []*IngressPathConfig{{Port: port}}}}
+		return rules, true, validateIngressRules(rules)
+	}
+	dec := yaml.NewDecoder(strings.NewReader(value))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rules); err != nil {
+		return nil, false, fmt.Errorf("the ingress must be a port or a list of rules: %w", err)
+	}
+	return rules, false, validateIngressRules(rules)
+}
+
+// validateIngressRules checks the rules and sets the default path and path type.
+func validateIngressRules(rules []*IngressRuleConfig) error {
+	if len(rules) == 0 {
+		return fmt.Errorf("the ingress has got no rule")
+	}
+	for _, rule := range rules {
+		if len(rule.Paths) == 0 {
+			return fmt.Errorf("the ingress rule for host %q has got no path", rule.Host)
+		}
+		for _, p := range rule.Paths {
+			if p.Port <= 0 || p.Port > 65535 {
+				return fmt.Errorf("%d is not a valid ingress port", p.Port)
+			}
+			if p.Path == "" {
+				p.Path = "/"
+			}
+			switch p.PathType {
+			case "":
+				p.PathType = "Prefix"
+			case "Prefix", "Exact", "ImplementationSpecific":
+			default:
+				return fmt.Errorf("%q is not a valid path type", p.PathType)
+			}
+		}
+	}
+	return nil
+}
@@ -41,11 +41,15 @@ func NewBase() *K8sBase {
 	return b
 }
 
-func (k *K8sBase) BuildSHA(filename string) {
-	c, _ := ioutil.ReadFile(filename)
-	//sum := sha256.Sum256(c)
-	sum := sha1.Sum(c)
-	k.Metadata.Annotations[K+"/docker-compose-sha1"] = fmt.Sprintf("%x", string(sum[:]))
+// BuildSHA sets the signature of the given compose files, in order, in the annotations.
+func (k *K8sBase) BuildSHA(filenames []string) {
+	//sum := sha256.New()
+	sum := sha1.New()
+	for _, filename := range filenames {
+		c, _ := ioutil.ReadFile(filename)
+		sum.Write(c)
+	}
+	k.Metadata.Annotations[K+"/docker-compose-sha1"] = fmt.Sprintf("%x", sum.Sum(nil))
 }
 
 // Get returns the Kind.
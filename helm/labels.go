@@ -7,17 +7,25 @@ import (
 
 const ReleaseNameTpl = "{{ .Release.Name }}"
 const (
-	LABEL_MAP_ENV     = K + "/mapenv"
-	LABEL_ENV_SECRET  = K + "/secret-envfiles"
-	LABEL_PORT        = K + "/ports"
-	LABEL_INGRESS     = K + "/ingress"
-	LABEL_VOL_CM      = K + "/configmap-volumes"
-	LABEL_HEALTHCHECK = K + "/healthcheck"
-	LABEL_SAMEPOD     = K + "/same-pod"
-	LABEL_VOLUMEFROM  = K + "/volume-from"
-	LABEL_EMPTYDIRS   = K + "/empty-dirs"
-	LABEL_IGNORE      = K + "/ignore"
-	LABEL_SECRETVARS  = K + "/secret-vars"
+	LABEL_MAP_ENV      = K + "/mapenv"
+	LABEL_ENV_SECRET   = K + "/secret-envfiles"
+	LABEL_PORT         = K + "/ports"
+	LABEL_INGRESS      = K + "/ingress"
+	LABEL_VOL_CM       = K + "/configmap-volumes"
+	LABEL_HEALTHCHECK  = K + "/healthcheck"
+	LABEL_SAMEPOD      = K + "/same-pod"
+	LABEL_VOLUMEFROM   = K + "/volume-from"
+	LABEL_EMPTYDIRS    = K + "/empty-dirs"
+	LABEL_IGNORE       = K + "/ignore"
+	LABEL_SECRETVARS   = K + "/secret-vars"
+	LABEL_WORKLOAD     = K + "/workload"
+	LABEL_CRONJOB      = K + "/cronjob"
+	LABEL_READINESS    = K + "/readiness"
+	LABEL_STARTUP      = K + "/startup"
+	LABEL_INFER_PROBES = K + "/infer-probes"
+	LABEL_SHARED_INGR  = K + "/shared-ingress"
+	LABEL_VOL_SHARING  = K + "/volume-sharing"
+	LABEL_LOCAL_VOLS   = K + "/local-volumes"
 
 	//deprecated: use LABEL_MAP_ENV instead
 	LABEL_ENV_SERVICE = K + "/env-to-service"
@@ -32,30 +40,82 @@ func GetLabelsDocumentation() string {
 {{.LABEL_ENV_SECRET  | printf "%-33s"}}: set the given file names as a secret instead of configmap
 {{.LABEL_MAP_ENV     | printf "%-33s"}}: map environment variable to a template string (yaml style)
 {{.LABEL_PORT        | printf "%-33s"}}: set the ports to expose as a service (coma separated)
-{{.LABEL_INGRESS     | printf "%-33s"}}: set the port to expose in an ingress (coma separated)
+{{.LABEL_INGRESS     | printf "%-33s"}}: set the port to expose in an ingress, or the ingress rules (yaml style):
+{{ printf "%-35s" ""}}- host: example.com      # default is <service>.<app>.tld
+{{ printf "%-35s" ""}}  paths:
+{{ printf "%-35s" ""}}    - path: /api         # default is "/"
+{{ printf "%-35s" ""}}      pathType: Prefix   # or Exact, ImplementationSpecific
+{{ printf "%-35s" ""}}      port: 8080
+{{.LABEL_SHARED_INGR | printf "%-33s"}}: set the service in an ingress shared with other services, as "group[/path]" (default
+{{ printf "%-34s" ""}} path is "/"). The host of the group and the paths are set in the "group" values
 {{.LABEL_VOL_CM      | printf "%-33s"}}: specifies that the volumes points on a configmap (coma separated)
+{{.LABEL_LOCAL_VOLS  | printf "%-33s"}}: set how the local volumes are packaged (yaml style), by path:
+{{ printf "%-34s" ""}} ./config: configmap   # default for the files of the project
+{{ printf "%-34s" ""}} ./certs: secret
+{{ printf "%-34s" ""}} /var/run/docker.sock: hostPath   # default for the paths outside of the project
+{{ printf "%-34s" ""}} ./tmp: emptyDir
+{{ printf "%-34s" ""}} ./data: pvc   # a claim where an init container copies the files
+{{.LABEL_VOL_SHARING | printf "%-33s"}}: how the named volumes mounted by several deployments are shared, "rwx" (default) for
+{{ printf "%-34s" ""}} one ReadWriteMany claim, or "affinity" to run the pods on the node of the first deployment
 {{.LABEL_SAMEPOD     | printf "%-33s"}}: specifies that the pod should be deployed in the same pod than the given service name
 {{.LABEL_VOLUMEFROM  | printf "%-33s"}}: specifies that the volumes to be mounted from the given service (yaml style)
 {{.LABEL_EMPTYDIRS   | printf "%-33s"}}: specifies that the given volume names should be "emptyDir" instead of persistentVolumeClaim (coma separated)
 {{.LABEL_HEALTHCHECK | printf "%-33s"}}: specifies that the container should be monitored by a healthcheck, **it overrides the docker-compose healthcheck**. 
 {{ printf "%-34s" ""}} You can use these form of label values:
-{{ printf "%-35s" ""}}- "http://[not used address][:port][/path]" to specify an http healthcheck
+{{ printf "%-35s" ""}}- "http[s]://[user:password@][not used address][:port][/path]" to specify an http(s) healthcheck
 {{ printf "%-35s" ""}}- "tcp://[not used address]:port" to specify a tcp healthcheck
 {{ printf "%-35s" ""}}- other string is condidered as a "command" healthcheck
+{{.LABEL_INFER_PROBES | printf "%-33s"}}: set to "false" to keep the curl, wget, pg_isready and "nc -z" healthchecks as commands,
+{{ printf "%-34s" ""}} by default they are replaced by http or tcp checks
+{{.LABEL_READINESS   | printf "%-33s"}}: specifies the readiness probe, with the same form as the healthcheck label, by default the
+{{ printf "%-34s" ""}} healthcheck is used
+{{.LABEL_STARTUP     | printf "%-33s"}}: specifies the startup probe, with the same form as the healthcheck label, by default the
+{{ printf "%-34s" ""}} healthcheck is used if the docker-compose healthcheck has got a "start_period"
+{{.LABEL_WORKLOAD    | printf "%-33s"}}: the kind of workload to create, "deployment", "statefulset" or "daemonset". Without this
+{{ printf "%-34s" ""}} label, services in "global" deploy mode are deployed as "daemonset", and well known database
+{{ printf "%-34s" ""}} images that use named volumes are deployed as "statefulset"
+{{.LABEL_CRONJOB     | printf "%-33s"}}: run the service as a CronJob with the given schedule (e.g. "0 2 * * *") instead of a deployment
+
+# x-katenary extension
+The same options can be set, with YAML types, in the "{{.EXTENSION}}" extension of a service. Names are
+the label names without the "{{.K}}/" prefix, and the extension takes precedence over the labels. E.g.:
+
+    services:
+      webapp:
+        {{.EXTENSION}}:
+          ports: [80]
+          ingress: 80
+          secret-vars: [DB_PASSWORD]
+          mapenv:
+            DB_HOST: "{{"{{"}} .Release.Name }}-database"
+
+At the top level of the compose file, "{{.EXTENSION}}" sets the default options of every service, only
+"secret-envfiles", "configmap-volumes", "local-volumes", "empty-dirs", "infer-probes" and "volume-sharing" are
+allowed there.
     `)
 	buff := bytes.NewBuffer(nil)
 	t.Execute(buff, map[string]string{
-		"LABEL_ENV_SECRET":  LABEL_ENV_SECRET,
-		"LABEL_PORT":        LABEL_PORT,
-		"LABEL_INGRESS":     LABEL_INGRESS,
-		"LABEL_VOL_CM":      LABEL_VOL_CM,
-		"LABEL_HEALTHCHECK": LABEL_HEALTHCHECK,
-		"LABEL_SAMEPOD":     LABEL_SAMEPOD,
-		"LABEL_VOLUMEFROM":  LABEL_VOLUMEFROM,
-		"LABEL_EMPTYDIRS":   LABEL_EMPTYDIRS,
-		"LABEL_IGNORE":      LABEL_IGNORE,
-		"LABEL_MAP_ENV":     LABEL_MAP_ENV,
-		"LABEL_SECRETVARS":  LABEL_SECRETVARS,
+		"LABEL_ENV_SECRET":   LABEL_ENV_SECRET,
+		"LABEL_PORT":         LABEL_PORT,
+		"LABEL_INGRESS":      LABEL_INGRESS,
+		"LABEL_VOL_CM":       LABEL_VOL_CM,
+		"LABEL_HEALTHCHECK":  LABEL_HEALTHCHECK,
+		"LABEL_SAMEPOD":      LABEL_SAMEPOD,
+		"LABEL_VOLUMEFROM":   LABEL_VOLUMEFROM,
+		"LABEL_EMPTYDIRS":    LABEL_EMPTYDIRS,
+		"LABEL_IGNORE":       LABEL_IGNORE,
+		"LABEL_MAP_ENV":      LABEL_MAP_ENV,
+		"LABEL_SECRETVARS":   LABEL_SECRETVARS,
+		"LABEL_WORKLOAD":     LABEL_WORKLOAD,
+		"LABEL_CRONJOB":      LABEL_CRONJOB,
+		"LABEL_SHARED_INGR":  LABEL_SHARED_INGR,
+		"LABEL_VOL_SHARING":  LABEL_VOL_SHARING,
+		"LABEL_LOCAL_VOLS":   LABEL_LOCAL_VOLS,
+		"LABEL_READINESS":    LABEL_READINESS,
+		"LABEL_INFER_PROBES": LABEL_INFER_PROBES,
+		"LABEL_STARTUP":      LABEL_STARTUP,
+		"EXTENSION":          EXTENSION,
+		"K":                  K,
 	})
 	return buff.String()
 }
@@ -16,8 +16,19 @@ func GenerateNotesFile(ingressess map[string]*Ingress) string {
 	list := make([]string, 0)
 
 	for name, ing := range ingressess {
+		scheme := "http{{ if .Values." + name + ".ingress.tls.enabled }}s{{ end }}://"
 		for _, r := range ing.Spec.Rules {
-			list = append(list, "{{ if .Values."+name+".ingress.enabled -}}\n- "+name+" is accessible on : http://"+r.Host+"\n{{- end }}")
+			shared := false
+			for _, p := range r.Http.Paths {
+				// the services of a shared ingress are accessible on the host with their path
+				if p.Service != "" {
+					shared = true
+					list = append(list, "{{ if and .Values."+name+".enabled .Values."+name+".ingress.enabled .Values."+p.Service+".enabled -}}\n- "+p.Service+" is accessible on : "+scheme+r.Host+p.Path+"\n{{- end }}")
+				}
+			}
+			if !shared {
+				list = append(list, "{{ if and .Values."+name+".enabled .Values."+name+".ingress.enabled -}}\n- "+name+" is accessible on : "+scheme+r.Host+"\n{{- end }}")
+			}
 		}
 	}
 
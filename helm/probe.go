@@ -1,20 +1,31 @@
 package helm
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/compose-spec/compose-go/types"
 )
 
-// Probe is a struct that can be used to create a Liveness or Readiness probe.
+// Probe is a struct that can be used to create a Liveness, Readiness or Startup probe. The timings are
+// numbers, or templates that render numbers.
 type Probe struct {
-	HttpGet      *HttpGet `yaml:"httpGet,omitempty"`
-	Exec         *Exec    `yaml:"exec,omitempty"`
-	TCP          *TCP     `yaml:"tcp,omitempty"`
-	Period       float64  `yaml:"periodSeconds"`
-	InitialDelay float64  `yaml:"initialDelaySeconds"`
-	Success      uint64   `yaml:"successThreshold"`
-	Failure      uint64   `yaml:"failureThreshold"`
+	HttpGet      *HttpGet    `yaml:"httpGet,omitempty"`
+	Exec         *Exec       `yaml:"exec,omitempty"`
+	TCP          *TCP        `yaml:"tcpSocket,omitempty"`
+	Period       interface{} `yaml:"periodSeconds"`
+	InitialDelay interface{} `yaml:"initialDelaySeconds"`
+	Timeout      interface{} `yaml:"timeoutSeconds"`
+	Success      interface{} `yaml:"successThreshold"`
+	Failure      interface{} `yaml:"failureThreshold"`
 }
 
 // Create a new Probe object that can be apply to HttpProbe or TCPProbe.
@@ -24,18 +35,19 @@ func NewProbe(period, initialDelaySeconds float64, success, failure uint64) *Pro
 		Success:      success,
 		Failure:      failure,
 		InitialDelay: initialDelaySeconds,
+		Timeout:      1,
 	}
 
 	// fix default values from
 	// https://kubernetes.io/docs/tasks/configure-pod-container/configure-liveness-readiness-startup-probes/
 	if period == 0 {
-		probe.Period = 10
+		probe.Period = float64(10)
 	}
 	if success == 0 {
-		probe.Success = 1
+		probe.Success = uint64(1)
 	}
 	if failure == 0 {
-		probe.Failure = 3
+		probe.Failure = uint64(3)
 	}
 	return probe
 }
@@ -73,7 +85,8 @@ func NewProbeWithDuration(period, initialDelaySeconds *types.Duration, success,
 		i = time.Second * 0
 	}
 
-	return NewProbe(p.Seconds(), i.Seconds(), *success, *failure)
+	// Kubernetes only accepts whole seconds
+	return NewProbe(math.Ceil(p.Seconds()), math.Ceil(i.Seconds()), *success, *failure)
 
 }
 
@@ -83,14 +96,69 @@ func NewProbeFromService(s *types.ServiceConfig) *Probe {
 		return NewProbe(0, 0, 0, 0)
 	}
 
-	return NewProbeWithDuration(s.HealthCheck.Interval, s.HealthCheck.StartPeriod, nil, s.HealthCheck.Retries)
-
+	p := NewProbeWithDuration(s.HealthCheck.Interval, s.HealthCheck.StartPeriod, nil, s.HealthCheck.Retries)
+	if s.HealthCheck.Timeout != nil && *s.HealthCheck.Timeout > 0 {
+		// Kubernetes only accepts whole seconds, at least 1
+		timeout := int(math.Ceil(time.Duration(*s.HealthCheck.Timeout).Seconds()))
+		if timeout < 1 {
+			timeout = 1
+		}
+		p.Timeout = timeout
+	}
+	return p
 }
 
 // HttpGet is a Probe configuration to check http health.
 type HttpGet struct {
-	Path string `yaml:"path"`
-	Port int    `yaml:"port"`
+	Path        string        `yaml:"path"`
+	Port        int           `yaml:"port"`
+	Scheme      string        `yaml:"scheme,omitempty"`
+	HttpHeaders []*HttpHeader `yaml:"httpHeaders,omitempty"`
+}
+
+// HttpHeader is a header sent by a HttpGet probe.
+type HttpHeader struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// NewHttpGet creates a HttpGet from a "http://" or "https://" url. The host is not used, the port is 80 or 443 by
+// default, and the user information is sent as basic authorization.
+func NewHttpGet(u *url.URL) (*HttpGet, error) {
+	h := &HttpGet{Path: "/", Port: 80}
+	switch u.Scheme {
+	case "http":
+	case "https":
+		h.Scheme = "HTTPS"
+		h.Port = 443
+	default:
+		return nil, fmt.Errorf("%s is not an http url", u.String())
+	}
+
+	if u.Port() != "" {
+		port, err := strconv.Atoi(u.Port())
+		if err != nil {
+			return nil, fmt.Errorf("the port of %s is not valid", u.String())
+		}
+		h.Port = port
+	}
+	if u.Path != "" {
+		h.Path = u.Path
+	}
+	if u.RawQuery != "" {
+		h.Path += "?" + u.RawQuery
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		h.AddHeader("Authorization", "Basic "+auth)
+	}
+	return h, nil
+}
+
+// AddHeader adds a header to send.
+func (h *HttpGet) AddHeader(name, value string) {
+	h.HttpHeaders = append(h.HttpHeaders, &HttpHeader{Name: name, Value: value})
 }
 
 // Execis a Probe configuration to check exec health.
@@ -98,7 +166,381 @@ type Exec struct {
 	Command []string `yaml:"command"`
 }
 
+// NewExecFromHealthCheck creates an Exec from the test of a compose healthcheck. "CMD-SHELL" tests are run
+// with "sh -c". It returns nil if the test is "NONE" or empty.
+func NewExecFromHealthCheck(test types.HealthCheckTest) *Exec {
+	if len(test) == 0 {
+		return nil
+	}
+	switch test[0] {
+	case "NONE":
+		return nil
+	case "CMD":
+		return &Exec{Command: test[1:]}
+	case "CMD-SHELL":
+		return &Exec{Command: []string{"sh", "-c", strings.Join(test[1:], " ")}}
+	default:
+		// a string test without CMD-SHELL, it's a shell command too
+		return &Exec{Command: []string{"sh", "-c", strings.Join(test, " ")}}
+	}
+}
+
 // TCP is a Probe configuration to check tcp health.
 type TCP struct {
 	Port int `yaml:"port"`
 }
+
+// NewTCP creates a TCP from a "tcp://" url, the port is mandatory.
+func NewTCP(u *url.URL) (*TCP, error) {
+	if u.Scheme != "tcp" {
+		return nil, fmt.Errorf("%s is not a tcp url", u.String())
+	}
+	if u.Port() == "" {
+		return nil, errors.New("the port of " + u.String() + " is missing")
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return nil, fmt.Errorf("the port of %s is not valid", u.String())
+	}
+	return &TCP{Port: port}, nil
+}
+
+// shellRedirectRE matches the redirections to /dev/null, they don't change the result of a check.
+var shellRedirectRE = regexp.MustCompile(`\s*(?:[12]?>|&>)\s*(?:/dev/null|&[12])`)
+
+// shellExitRE matches the "|| exit 1" at the end of a check.
+var shellExitRE = regexp.MustCompile(`\s*\|\|\s*(?:exit(?:\s+\d+)?|false)\s*$`)
+
+// InferHandler tries to replace the test of a compose healthcheck by a native check. It recognizes the curl,
+// wget, pg_isready and "nc -z" commands that check the container itself, and returns a HttpGet or a TCP. The
+// error explains why the test is not recognized.
+func InferHandler(test types.HealthCheckTest) (*HttpGet, *TCP, error) {
+	if len(test) == 0 || test[0] == "NONE" {
+		return nil, nil, errors.New("no test")
+	}
+	var args []string
+	if test[0] == "CMD" {
+		args = test[1:]
+	} else {
+		command := strings.Join(test, " ")
+		if test[0] == "CMD-SHELL" {
+			command = strings.Join(test[1:], " ")
+		}
+		var err error
+		if args, err = splitShellCommand(command); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(args) == 0 {
+		return nil, nil, errors.New("no command")
+	}
+
+	switch path.Base(args[0]) {
+	case "curl":
+		h, err := inferCurl(args[1:])
+		return h, nil, err
+	case "wget":
+		h, err := inferWget(args[1:])
+		return h, nil, err
+	case "pg_isready":
+		tcp, err := inferPgIsReady(args[1:])
+		return nil, tcp, err
+	case "nc", "netcat":
+		tcp, err := inferNetcat(args[1:])
+		return nil, tcp, err
+	}
+	return nil, nil, fmt.Errorf("%s is not a known command", args[0])
+}
+
+// splitShellCommand splits a simple shell command in arguments. Redirections to /dev/null and a final
+// "|| exit 1" are ignored, other shell features make an error.
+func splitShellCommand(command string) ([]string, error) {
+	command = shellExitRE.ReplaceAllString(shellRedirectRE.ReplaceAllString(command, ""), "")
+	args := make([]string, 0)
+	current, inArg, quote := "", false, rune(0)
+	for _, c := range command {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			current += string(c)
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, current)
+			}
+			current, inArg = "", false
+		case strings.ContainsRune("|&;<>$`()\\", c):
+			return nil, fmt.Errorf("the %q shell feature is not supported", c)
+		default:
+			current += string(c)
+			inArg = true
+		}
+		if quote == '"' && strings.ContainsRune("$`\\", c) {
+			return nil, fmt.Errorf("the %q shell feature is not supported", c)
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, current)
+	}
+	return args, nil
+}
+
+// localURL parses the url of a check, it must be an http url on the container itself.
+func localURL(rawurl string) (*url.URL, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if !isLocalHost(u.Hostname()) {
+		return nil, fmt.Errorf("%s is not a check of the container itself", rawurl)
+	}
+	return u, nil
+}
+
+func isLocalHost(host string) bool {
+	switch host {
+	case "", "localhost", "127.0.0.1", "0.0.0.0", "::1":
+		return true
+	}
+	return false
+}
+
+// addHeaderArg adds a "Name: value" header to the check.
+func addHeaderArg(h *HttpGet, header string) error {
+	kv := strings.SplitN(header, ":", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("the header %q is not valid", header)
+	}
+	h.AddHeader(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	return nil
+}
+
+// inferCurl recognizes "curl [-fsSkLI] [-H header] [-u user:password] [-X GET] url".
+func inferCurl(args []string) (*HttpGet, error) {
+	rawurl, headers, user := "", []string{}, ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := func() (string, error) {
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("the %s option needs a value", arg)
+			}
+			i++
+			return args[i], nil
+		}
+		var err error
+		switch {
+		case arg == "-H" || arg == "--header":
+			var header string
+			header, err = value()
+			headers = append(headers, header)
+		case arg == "-u" || arg == "--user":
+			user, err = value()
+		case arg == "-X" || arg == "--request":
+			var method string
+			if method, err = value(); err == nil && method != "GET" && method != "HEAD" {
+				err = fmt.Errorf("the %s method cannot be checked", method)
+			}
+		case arg == "-m" || arg == "--max-time" || arg == "--connect-timeout" || arg == "-o" || arg == "--output":
+			_, err = value()
+		case strings.HasPrefix(arg, "--"):
+			switch arg {
+			case "--fail", "--silent", "--show-error", "--insecure", "--location", "--head", "--verbose":
+			default:
+				err = fmt.Errorf("the %s option is not supported", arg)
+			}
+		case strings.HasPrefix(arg, "-"):
+			if strings.Trim(arg[1:], "fsSkLIv") != "" {
+				err = fmt.Errorf("the %s option is not supported", arg)
+			}
+		case rawurl != "":
+			err = errors.New("only one url can be checked")
+		default:
+			rawurl = arg
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rawurl == "" {
+		return nil, errors.New("no url to check")
+	}
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "http://" + rawurl
+	}
+	if user != "" {
+		rawurl = strings.Replace(rawurl, "://", "://"+user+"@", 1)
+	}
+	return buildInferredHttpGet(rawurl, headers)
+}
+
+// inferWget recognizes "wget [-q] [--spider] [-O file] [-T timeout] [--header header] url".
+func inferWget(args []string) (*HttpGet, error) {
+	rawurl, headers, user, password := "", []string{}, "", ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := arg, "", false
+		if strings.HasPrefix(arg, "--") && strings.Contains(arg, "=") {
+			kv := strings.SplitN(arg, "=", 2)
+			name, value, hasValue = kv[0], kv[1], true
+		}
+		needValue := func() error {
+			if hasValue {
+				return nil
+			}
+			if i+1 >= len(args) {
+				return fmt.Errorf("the %s option needs a value", arg)
+			}
+			i++
+			value = args[i]
+			return nil
+		}
+		var err error
+		switch {
+		case name == "--header":
+			if err = needValue(); err == nil {
+				headers = append(headers, value)
+			}
+		case name == "--user" || name == "--http-user":
+			if err = needValue(); err == nil {
+				user = value
+			}
+		case name == "--password" || name == "--http-password":
+			if err = needValue(); err == nil {
+				password = value
+			}
+		case name == "--output-document" || name == "--timeout" || name == "--tries":
+			err = needValue()
+		case strings.HasPrefix(name, "--"):
+			switch name {
+			case "--quiet", "--spider", "--no-verbose", "--no-check-certificate", "--server-response":
+			default:
+				err = fmt.Errorf("the %s option is not supported", name)
+			}
+		case name == "-nv":
+		case strings.HasPrefix(name, "-"):
+			// short options can be grouped, e.g. "-qO-" or "-q -O /dev/null"
+		short:
+			for j, c := range name[1:] {
+				switch c {
+				case 'q', 'S':
+				case 'O', 'T', 't':
+					if j+2 == len(name) {
+						err = needValue()
+					}
+					break short
+				default:
+					err = fmt.Errorf("the %s option is not supported", name)
+					break short
+				}
+			}
+		case rawurl != "":
+			err = errors.New("only one url can be checked")
+		default:
+			rawurl = arg
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rawurl == "" {
+		return nil, errors.New("no url to check")
+	}
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "http://" + rawurl
+	}
+	if user != "" {
+		rawurl = strings.Replace(rawurl, "://", "://"+url.UserPassword(user, password).String()+"@", 1)
+	}
+	return buildInferredHttpGet(rawurl, headers)
+}
+
+func buildInferredHttpGet(rawurl string, headers []string) (*HttpGet, error) {
+	u, err := localURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	h, err := NewHttpGet(u)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range headers {
+		if err := addHeaderArg(h, header); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// inferPgIsReady recognizes "pg_isready [-h host] [-p port] [-U user] [-d dbname] [-t timeout] [-q]".
+func inferPgIsReady(args []string) (*TCP, error) {
+	port := 5432
+	for i := 0; i < len(args); i++ {
+		name, value := args[i], ""
+		if strings.HasPrefix(name, "--") && strings.Contains(name, "=") {
+			kv := strings.SplitN(name, "=", 2)
+			name, value = kv[0], kv[1]
+		}
+		switch name {
+		case "-q", "--quiet":
+			continue
+		case "-h", "--host", "-p", "--port", "-U", "--username", "-d", "--dbname", "-t", "--timeout":
+		default:
+			return nil, fmt.Errorf("the %s option is not supported", name)
+		}
+		if value == "" {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("the %s option needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		switch name {
+		case "-h", "--host":
+			if !isLocalHost(value) {
+				return nil, fmt.Errorf("%s is not the container itself", value)
+			}
+		case "-p", "--port":
+			p, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("the port %s is not valid", value)
+			}
+			port = p
+		}
+	}
+	return &TCP{Port: port}, nil
+}
+
+// inferNetcat recognizes "nc -z [-w timeout] [-v] host port".
+func inferNetcat(args []string) (*TCP, error) {
+	scan := false
+	operands := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-w":
+			i++
+		case strings.HasPrefix(arg, "-"):
+			if strings.Trim(arg[1:], "zvn") != "" {
+				return nil, fmt.Errorf("the %s option is not supported", arg)
+			}
+			scan = scan || strings.Contains(arg, "z")
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	if !scan || len(operands) != 2 {
+		return nil, errors.New("only \"nc -z host port\" can be checked")
+	}
+	if !isLocalHost(operands[0]) {
+		return nil, fmt.Errorf("%s is not the container itself", operands[0])
+	}
+	port, err := strconv.Atoi(operands[1])
+	if err != nil {
+		return nil, fmt.Errorf("the port %s is not valid", operands[1])
+	}
+	return &TCP{Port: port}, nil
+}
@@ -0,0 +1,81 @@
+package helm
+
+import "fmt"
+
+// Route is an OpenShift route, it's an alternative to the Ingress.
+type Route struct {
+	*K8sBase `yaml:",inline"`
+	Spec     *RouteSpec `yaml:"spec"`
+	Service  string     `yaml:"-"` // the service of the route in a shared ingress, the route is kept if it's enabled
+}
+
+// RouteSpec is the spec of a Route. A route has got one host, one path and one service.
+type RouteSpec struct {
+	Host string       `yaml:"host"`
+	Path string       `yaml:"path,omitempty"`
+	To   *RouteTarget `yaml:"to"`
+	Port *RoutePort   `yaml:"port"`
+	TLS  *RouteTLS    `yaml:"-"` // written with a condition on values
+}
+
+// RouteTarget is the service that gets the requests.
+type RouteTarget struct {
+	Kind   string `yaml:"kind"`
+	Name   string `yaml:"name"`
+	Weight int    `yaml:"weight"`
+}
+
+// RoutePort is the port of the pods, a number or the name of the port.
+type RoutePort struct {
+	TargetPort interface{} `yaml:"targetPort"`
+}
+
+// RouteTLS is the TLS termination of a route, "edge", "passthrough" or "reencrypt".
+type RouteTLS struct {
+	Termination                   string `yaml:"termination"`
+	InsecureEdgeTerminationPolicy string `yaml:"insecureEdgeTerminationPolicy"`
+}
+
+// NewRoute creates a route for the service, the TLS termination is set in values.
+func NewRoute(name string) *Route {
+	r := &Route{
+		K8sBase: NewBase(),
+		Spec: &RouteSpec{
+			TLS: &RouteTLS{
+				Termination:                   "{{ .Values." + name + ".ingress.route.termination }}",
+				InsecureEdgeTerminationPolicy: "{{ .Values." + name + ".ingress.route.insecureEdgeTerminationPolicy }}",
+			},
+		},
+	}
+	r.K8sBase.Metadata.Name = ReleaseNameTpl + "-" + name
+	r.K8sBase.Kind = "Route"
+	r.K8sBase.ApiVersion = "route.openshift.io/v1"
+	r.K8sBase.Metadata.Labels[K+"/component"] = name
+	return r
+}
+
+// NewRoutesFromIngress creates the routes that do the same as the ingress, there is a route for each path.
+func NewRoutesFromIngress(name string, ingress *Ingress) []*Route {
+	routes := make([]*Route, 0)
+	for _, rule := range ingress.Spec.Rules {
+		for _, p := range rule.Http.Paths {
+			route := NewRoute(name)
+			if len(routes) > 0 {
+				route.Metadata.Name = fmt.Sprintf("%s-%d", route.Metadata.Name, len(routes))
+			}
+			for k, v := range ingress.Metadata.Annotations {
+				route.Metadata.Annotations[k] = v
+			}
+			route.Spec.Host = rule.Host
+			route.Spec.Path = p.Path
+			route.Spec.To = &RouteTarget{Kind: "Service", Name: p.Backend.Service.Name, Weight: 100}
+			route.Spec.Port = &RoutePort{TargetPort: p.Backend.Service.Port["number"]}
+			if p.PortName != "" {
+				route.Spec.Port.TargetPort = p.PortName
+			}
+			route.Service = p.Service
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
@@ -19,27 +19,38 @@ func NewService(name string) *Service {
 	return s
 }
 
-// ServicePort is a port on a service.
+// NewHeadlessService creates a service without cluster IP for the StatefulSet of the given name.
+func NewHeadlessService(name string) *Service {
+	s := NewService(name + "-headless")
+	s.K8sBase.Metadata.Labels[K+"/component"] = name
+	s.Spec.ClusterIP = "None"
+	return s
+}
+
+// ServicePort is a port on a service. Ports are int or templates that render an int.
 type ServicePort struct {
-	Protocol   string `yaml:"protocol"`
-	Port       int    `yaml:"port"`
-	TargetPort int    `yaml:"targetPort"`
+	Name       string      `yaml:"name,omitempty"`
+	Protocol   string      `yaml:"protocol"`
+	Port       interface{} `yaml:"port"`
+	TargetPort interface{} `yaml:"targetPort"`
+	NodePort   interface{} `yaml:"nodePort,omitempty"`
 }
 
 // NewServicePort creates a new initialized service port.
-func NewServicePort(port, target int) *ServicePort {
+func NewServicePort(port, target interface{}) *ServicePort {
 	return &ServicePort{
 		Protocol:   "TCP",
 		Port:       port,
-		TargetPort: port,
+		TargetPort: target,
 	}
 }
 
 // ServiceSpec is the spec for a service.
 type ServiceSpec struct {
-	Selector map[string]string
-	Ports    []*ServicePort
-	Type     string `yaml:"type,omitempty"`
+	Selector  map[string]string
+	Ports     []*ServicePort
+	Type      string `yaml:"type,omitempty"`
+	ClusterIP string `yaml:"clusterIP,omitempty"`
 }
 
 // NewServiceSpec creates a new initialized service spec.
@@ -17,8 +17,9 @@ func ResetMadePVC() {
 
 // Storage is a struct for a PersistentVolumeClaim.
 type Storage struct {
-	*K8sBase `yaml:",inline"`
-	Spec     *PVCSpec
+	*K8sBase    `yaml:",inline"`
+	Spec        *PVCSpec
+	Deployments []string `yaml:"-"` // the deployments that share the claim, it's created if one of them is enabled
 }
 
 // NewPVC creates a new PersistentVolumeClaim object.
@@ -36,19 +37,46 @@ func NewPVC(name, storageName string) *Storage {
 	pvc.K8sBase.ApiVersion = "v1"
 	pvc.K8sBase.Metadata.Name = ReleaseNameTpl + "-" + storageName
 	pvc.K8sBase.Metadata.Labels[K+"/component"] = name
-	pvc.Spec = &PVCSpec{
+	pvc.Spec = newPVCSpec(name, storageName)
+	return pvc
+}
+
+// VolumeClaimTemplate is a PersistentVolumeClaim template of a StatefulSet.
+type VolumeClaimTemplate struct {
+	Metadata *Metadata `yaml:"metadata"`
+	Spec     *PVCSpec  `yaml:"spec"`
+}
+
+// NewVolumeClaimTemplate creates the claim template of the storageName volume, it uses the same values as NewPVC.
+func NewVolumeClaimTemplate(name, storageName string) *VolumeClaimTemplate {
+	metadata := NewMetadata()
+	metadata.Name = storageName
+	metadata.Labels[K+"/component"] = name
+	metadata.Labels[K+"/pvc-name"] = storageName
+	return &VolumeClaimTemplate{
+		Metadata: metadata,
+		Spec:     newPVCSpec(name, storageName),
+	}
+}
+
+// newPVCSpec creates the spec of the storageName volume, the capacity, the access modes and the storage class
+// are set in values.
+func newPVCSpec(name, storageName string) *PVCSpec {
+	values := ".Values." + name + ".persistence." + storageName
+	return &PVCSpec{
 		Resouces: map[string]interface{}{
 			"requests": map[string]string{
-				"storage": "{{ .Values." + name + ".persistence." + storageName + ".capacity }}",
+				"storage": "{{ " + values + ".capacity }}",
 			},
 		},
-		AccessModes: []string{"ReadWriteOnce"},
+		AccessModes:      "{{ toYaml " + values + ".accessModes }}",
+		StorageClassName: "{{ " + values + ".storageClass }}",
 	}
-	return pvc
 }
 
 // PVCSpec is a struct for a PersistentVolumeClaim spec.
 type PVCSpec struct {
-	Resouces    map[string]interface{} `yaml:"resources"`
-	AccessModes []string               `yaml:"accessModes"`
+	Resouces         map[string]interface{} `yaml:"resources"`
+	AccessModes      interface{}            `yaml:"accessModes"`
+	StorageClassName string                 `yaml:"storageClassName,omitempty"`
 }
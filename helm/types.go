@@ -20,8 +20,8 @@ type Kinded interface {
 
 // Signable represents an object with a signature.
 type Signable interface {
-	// BuildSHA must return the signature.
-	BuildSHA(filename string)
+	// BuildSHA must set the signature of the given files.
+	BuildSHA(filenames []string)
 }
 
 // Named represents an object with a name.